@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables read by the command, e.g. SIGNALINGSERVER_ADDR
+const envPrefix = "SIGNALINGSERVER_"
+
+// config holds the command settings. They are resolved in this order, later sources
+// overriding earlier ones: defaults, config file, environment variables, command-line flags.
+type config struct {
+	Addr                  string   `json:"addr" yaml:"addr"`
+	Path                  string   `json:"path" yaml:"path"`
+	TLSCert               string   `json:"tlsCert" yaml:"tlsCert"`
+	TLSKey                string   `json:"tlsKey" yaml:"tlsKey"`
+	IDLength              int      `json:"idLength" yaml:"idLength"`
+	IDGenerator           string   `json:"idGenerator" yaml:"idGenerator"`
+	IDPrefix              string   `json:"idPrefix" yaml:"idPrefix"`
+	IdentifyMessageSender bool     `json:"identifyMessageSender" yaml:"identifyMessageSender"`
+	AddSelfToGetPeerIDs   bool     `json:"addSelfToGetPeerIDs" yaml:"addSelfToGetPeerIDs"`
+	AllowedOrigins        []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+	LogLevel              string   `json:"logLevel" yaml:"logLevel"`
+	LogFormat             string   `json:"logFormat" yaml:"logFormat"`
+	LogContents           bool     `json:"logContents" yaml:"logContents"`
+	AdminPath             string   `json:"adminPath" yaml:"adminPath"`
+	AdminToken            string   `json:"adminToken" yaml:"adminToken"`
+	MetricsPath           string   `json:"metricsPath" yaml:"metricsPath"`
+	ReconnectAfter        string   `json:"reconnectAfter" yaml:"reconnectAfter"`
+	RedisAddr             string   `json:"redisAddr" yaml:"redisAddr"`
+	RedisPassword         string   `json:"redisPassword" yaml:"redisPassword"`
+	NodeID                string   `json:"nodeID" yaml:"nodeID"`
+}
+
+func defaultConfig() config {
+	return config{
+		Addr:                  ":8090",
+		Path:                  "/signalingserver",
+		IDLength:              10,
+		IDGenerator:           "random",
+		IdentifyMessageSender: true,
+		AddSelfToGetPeerIDs:   false,
+		LogLevel:              "info",
+		LogFormat:             "text",
+		AdminPath:             "/admin",
+	}
+}
+
+// setting describes one config field and how to read it from the environment and flags.
+type setting struct {
+	flag   string
+	env    string
+	usage  string
+	isBool bool
+	// set parses a string value into the config
+	set func(c *config, value string) error
+}
+
+// flagValue records the raw value of a setting's flag, so it can be applied after the
+// config file and environment.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string     { return v.value }
+func (v *flagValue) Set(s string) error { v.value = s; return nil }
+func (v *flagValue) IsBoolFlag() bool   { return v.isBool }
+
+var settings = []setting{
+	{"addr", "ADDR", "address to listen on", false, func(c *config, v string) error {
+		c.Addr = v
+		return nil
+	}},
+	{"path", "PATH", "HTTP path of the websocket endpoint", false, func(c *config, v string) error {
+		c.Path = v
+		return nil
+	}},
+	{"tls-cert", "TLS_CERT", "TLS certificate file, serves plain HTTP when empty", false, func(c *config, v string) error {
+		c.TLSCert = v
+		return nil
+	}},
+	{"tls-key", "TLS_KEY", "TLS private key file", false, func(c *config, v string) error {
+		c.TLSKey = v
+		return nil
+	}},
+	{"id-length", "ID_LENGTH", "length of generated peer IDs", false, func(c *config, v string) (err error) {
+		c.IDLength, err = strconv.Atoi(v)
+		return err
+	}},
+	{"id-generator", "ID_GENERATOR", "peer ID format: random, uuid4, uuid7 or ulid", false, func(c *config, v string) error {
+		c.IDGenerator = v
+		return nil
+	}},
+	{"id-prefix", "ID_PREFIX", "prefix added to generated peer IDs", false, func(c *config, v string) error {
+		c.IDPrefix = v
+		return nil
+	}},
+	{"identify-message-sender", "IDENTIFY_MESSAGE_SENDER", "set the sender ID on relayed messages", true, func(c *config, v string) (err error) {
+		c.IdentifyMessageSender, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"add-self-to-get-peer-ids", "ADD_SELF_TO_GET_PEER_IDS", "include the requesting peer in GetAllPeerIDs responses", true, func(c *config, v string) (err error) {
+		c.AddSelfToGetPeerIDs, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"allowed-origins", "ALLOWED_ORIGINS", "comma-separated allowed origins, '*' for any; same-origin only when empty", false, func(c *config, v string) error {
+		c.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error", false, func(c *config, v string) error {
+		c.LogLevel = v
+		return nil
+	}},
+	{"log-format", "LOG_FORMAT", "log format: text or json", false, func(c *config, v string) error {
+		c.LogFormat = v
+		return nil
+	}},
+	{"log-contents", "LOG_CONTENTS", "log message contents, including SDP and ICE candidates, at debug level", true, func(c *config, v string) (err error) {
+		c.LogContents, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"admin-path", "ADMIN_PATH", "HTTP path prefix of the admin API", false, func(c *config, v string) error {
+		c.AdminPath = v
+		return nil
+	}},
+	{"admin-token", "ADMIN_TOKEN", "bearer token of the admin API, disabled when empty", false, func(c *config, v string) error {
+		c.AdminToken = v
+		return nil
+	}},
+	{"metrics-path", "METRICS_PATH", "HTTP path of the Prometheus metrics, disabled when empty", false, func(c *config, v string) error {
+		c.MetricsPath = v
+		return nil
+	}},
+	{"reconnect-after", "RECONNECT_AFTER", "how long clients wait to reconnect after a shutdown, spread over twice as long; no hint when empty", false, func(c *config, v string) error {
+		c.ReconnectAfter = v
+		return nil
+	}},
+	{"redis-addr", "REDIS_ADDR", "Redis server the nodes of a cluster share, host:port; runs alone when empty", false, func(c *config, v string) error {
+		c.RedisAddr = v
+		return nil
+	}},
+	{"redis-password", "REDIS_PASSWORD", "password of the Redis server", false, func(c *config, v string) error {
+		c.RedisPassword = v
+		return nil
+	}},
+	{"node-id", "NODE_ID", "ID of this server in the cluster, random when empty", false, func(c *config, v string) error {
+		c.NodeID = v
+		return nil
+	}},
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadConfig resolves the config from the config file, the environment and args.
+func loadConfig(args []string) (config, error) {
+	flags := flag.NewFlagSet("signalingserver", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(envPrefix+"CONFIG"), "JSON or YAML config file (env "+envPrefix+"CONFIG)")
+	values := make(map[string]*flagValue, len(settings))
+	for _, s := range settings {
+		values[s.flag] = &flagValue{isBool: s.isBool}
+		flags.Var(values[s.flag], s.flag, fmt.Sprintf("%s (env %s%s)", s.usage, envPrefix, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	c := defaultConfig()
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return config{}, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envPrefix + s.env); ok {
+			if err := s.set(&c, value); err != nil {
+				return config{}, fmt.Errorf("invalid %s%s: %w", envPrefix, s.env, err)
+			}
+		}
+	}
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(&c, values[s.flag].value); err != nil {
+					flagErr = fmt.Errorf("invalid -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return config{}, flagErr
+	}
+	return c, c.validate()
+}
+
+func (c *config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(c)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, use .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *config) validate() error {
+	if c.Addr == "" {
+		return errors.New("listen address must not be empty")
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("path %q must start with '/'", c.Path)
+	}
+	if c.IDLength <= 0 {
+		return fmt.Errorf("ID length must be positive, got %d", c.IDLength)
+	}
+	switch c.IDGenerator {
+	case "random", "uuid4", "uuid7", "ulid":
+	default:
+		return fmt.Errorf("unknown ID generator %q, use random, uuid4, uuid7 or ulid", c.IDGenerator)
+	}
+	if c.AdminToken != "" && (!strings.HasPrefix(c.AdminPath, "/") || c.AdminPath == "/" || c.AdminPath == c.Path) {
+		return fmt.Errorf("admin path %q must start with '/' and differ from the websocket path", c.AdminPath)
+	}
+	if c.MetricsPath != "" && (!strings.HasPrefix(c.MetricsPath, "/") || c.MetricsPath == c.Path) {
+		return fmt.Errorf("metrics path %q must start with '/' and differ from the websocket path", c.MetricsPath)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS certificate and key must be set together")
+	}
+	if _, err := c.slogLevel(); err != nil {
+		return err
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("unknown log format %q, use text or json", c.LogFormat)
+	}
+	if _, err := c.reconnectAfter(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *config) reconnectAfter() (time.Duration, error) {
+	if c.ReconnectAfter == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.ReconnectAfter)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid reconnect delay %q", c.ReconnectAfter)
+	}
+	return d, nil
+}
+
+func (c *config) slogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", c.LogLevel)
+	}
+	return level, nil
+}
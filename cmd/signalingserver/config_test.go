@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", `
+addr: ":9000"
+path: /file
+idLength: 12
+logLevel: warn
+allowedOrigins:
+  - https://file.example.com
+`)
+	t.Setenv(envPrefix+"CONFIG", file)
+	t.Setenv(envPrefix+"PATH", "/env")
+	t.Setenv(envPrefix+"ID_LENGTH", "14")
+	t.Setenv(envPrefix+"IDENTIFY_MESSAGE_SENDER", "false")
+
+	c, err := loadConfig([]string{"-id-length", "16", "-add-self-to-get-peer-ids", "-allowed-origins", "https://a.example.com, https://b.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultConfig()
+	want.Addr = ":9000"    // file
+	want.LogLevel = "warn" // file
+	want.Path = "/env"     // env over file
+	want.IdentifyMessageSender = false
+	want.IDLength = 16 // flag over env and file
+	want.AddSelfToGetPeerIDs = true
+	want.AllowedOrigins = []string{"https://a.example.com", "https://b.example.com"}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got config\n%+v\nwant\n%+v", c, want)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	json := writeConfigFile(t, "config.json", `{"addr": ":9100", "identifyMessageSender": false, "allowedOrigins": ["*"]}`)
+	c, err := loadConfig([]string{"-config", json})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":9100" || c.IdentifyMessageSender || !reflect.DeepEqual(c.AllowedOrigins, []string{"*"}) {
+		t.Errorf("got config %+v", c)
+	}
+
+	for name, content := range map[string]string{
+		"unknown.json": `{"adress": ":9100"}`,
+		"unknown.yaml": "adress: \":9100\"\n",
+		"config.toml":  `addr = ":9100"`,
+		"broken.json":  `{"addr":`,
+	} {
+		if _, err := loadConfig([]string{"-config", writeConfigFile(t, name, content)}); err == nil {
+			t.Errorf("%s: loaded %q", name, content)
+		}
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	for _, args := range [][]string{
+		{"-id-length", "0"},
+		{"-id-length", "ten"},
+		{"-path", "signalingserver"},
+		{"-tls-cert", "cert.pem"},
+		{"-log-level", "loud"},
+		{"-log-format", "xml"},
+		{"-id-generator", "sequential"},
+		{"-reconnect-after", "soon"},
+		{"-metrics-path", "/signalingserver"},
+		{"-identify-message-sender=maybe"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: accepted", args)
+		}
+	}
+	t.Setenv(envPrefix+"ID_LENGTH", "ten")
+	if _, err := loadConfig(nil); err == nil {
+		t.Errorf("invalid %sID_LENGTH accepted", envPrefix)
+	}
+}
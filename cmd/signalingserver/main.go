@@ -0,0 +1,138 @@
+// Command signalingserver runs a standalone websocket signaling server.
+//
+// Settings come from, in increasing order of precedence: built-in defaults, a JSON or YAML
+// config file (-config or SIGNALINGSERVER_CONFIG), SIGNALINGSERVER_* environment variables
+// and command-line flags. Run with -h for the full list.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/redisbroker"
+)
+
+// Time given to in-flight requests to complete when the server is stopped
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run serves until the process is told to stop, and returns its exit code. Returning
+// rather than exiting lets the deferred cleanup, like closing the Redis broker, happen.
+func run(args []string) int {
+	cfg, err := loadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		return 1
+	}
+	level, _ := cfg.slogLevel()
+	reconnectAfter, _ := cfg.reconnectAfter()
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, handlerOptions)
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, handlerOptions)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+
+	options := []signalingserver.Option{
+		signalingserver.WithLogging(signalingserver.LogConfig{Logger: logger, Debug: cfg.LogContents}),
+		signalingserver.WithOriginPolicy(originPolicy(cfg.AllowedOrigins)),
+		signalingserver.WithShutdownConfig(signalingserver.ShutdownConfig{ReconnectAfter: reconnectAfter, ReconnectJitter: reconnectAfter}),
+		signalingserver.WithIDGenerator(idGenerator(cfg.IDGenerator, cfg.IDLength, cfg.IDPrefix)),
+	}
+	if cfg.RedisAddr != "" {
+		broker := redisbroker.New(redisbroker.Config{Addr: cfg.RedisAddr, Password: cfg.RedisPassword, Logger: logger})
+		defer broker.Close()
+		options = append(options, signalingserver.WithCluster(signalingserver.ClusterConfig{Broker: broker, NodeID: cfg.NodeID}))
+	}
+	signalingServer := signalingserver.NewSignalingServer(cfg.IDLength, cfg.IdentifyMessageSender, cfg.AddSelfToGetPeerIDs, options...)
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Path, signalingServer.HandleWebSocketConn)
+	if cfg.MetricsPath != "" {
+		mux.Handle(cfg.MetricsPath, signalingServer.MetricsHandler())
+	}
+	if cfg.AdminToken != "" {
+		adminPath := strings.TrimSuffix(cfg.AdminPath, "/")
+		mux.Handle(adminPath+"/", http.StripPrefix(adminPath, signalingServer.AdminHandler(signalingserver.AdminConfig{Token: cfg.AdminToken})))
+	}
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			logger.Info("Signaling server available", "url", "wss://"+cfg.Addr+cfg.Path)
+			serveErr <- server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			logger.Info("Signaling server available", "url", "ws://"+cfg.Addr+cfg.Path)
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		logger.Error("Error serving the signaling server", "error", err)
+		return 1
+	case <-ctx.Done():
+		logger.Info("Shutting down the signaling server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := signalingServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Error closing peer connections", "error", err)
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Error shutting down", "error", err)
+		}
+	}
+	return 0
+}
+
+func originPolicy(allowedOrigins []string) signalingserver.OriginPolicy {
+	if len(allowedOrigins) == 0 {
+		return signalingserver.AllowSameOrigin()
+	}
+	if slices.Contains(allowedOrigins, "*") {
+		return signalingserver.AllowAllOrigins()
+	}
+	return signalingserver.AllowOrigins(allowedOrigins...)
+}
+
+func idGenerator(name string, length int, prefix string) signalingserver.IDGenerator {
+	var generator signalingserver.IDGenerator
+	switch name {
+	case "uuid4":
+		generator = signalingserver.UUIDv4Generator()
+	case "uuid7":
+		generator = signalingserver.UUIDv7Generator()
+	case "ulid":
+		generator = signalingserver.ULIDGenerator()
+	default:
+		generator = signalingserver.RandomIDGenerator(length)
+	}
+	if prefix != "" {
+		generator = signalingserver.PrefixedIDGenerator(prefix, generator)
+	}
+	return generator
+}
@@ -8,7 +8,9 @@ import (
 )
 
 func main() {
-	signalingServer := signalingserver.NewSignalingServer(20, true, true)
+	signalingServer := signalingserver.NewSignalingServer(20, true, true,
+		// the pages of the example are served from another origin
+		signalingserver.WithOriginPolicy(signalingserver.AllowAllOrigins()))
 	http.HandleFunc("/signalingserver", signalingServer.HandleWebSocketConn)
 	log.Println("Server listening on :8090")
 	err := http.ListenAndServe(":8090", nil)
@@ -8,7 +8,9 @@ import (
 )
 
 func main() {
-	signalingServer := signalingserver.NewSignalingServer(10, true, false)
+	signalingServer := signalingserver.NewSignalingServer(10, true, false,
+		// the pages of the example are served from another origin
+		signalingserver.WithOriginPolicy(signalingserver.AllowAllOrigins()))
 	http.HandleFunc("/signalingserver", signalingServer.HandleWebSocketConn)
 	log.Println("Signaling server available at localhost:8090")
 	err := http.ListenAndServe(":8090", nil)
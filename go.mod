@@ -2,4 +2,10 @@ module github.com/AbdelrahmanWM/signalingserver
 
 go 1.23.0
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/gorilla/websocket v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/x448/float16 v0.8.4 // indirect
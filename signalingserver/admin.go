@@ -0,0 +1,285 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// messageTotals count the messages of all peers since the server started.
+type messageTotals struct {
+	received    atomic.Uint64
+	sent        atomic.Uint64
+	writeErrors atomic.Uint64
+}
+
+// connectedFrom records the client behind the request of the latest connection of p.
+func (p *peer) connectedFrom(r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remoteAddr = r.RemoteAddr
+	p.userAgent = r.UserAgent()
+}
+
+// countReceived counts a message read from p, of kind if it could be decoded.
+func (p *peer) countReceived(kind message.MessageType, decoded bool) {
+	p.received.Add(1)
+	if p.totals != nil {
+		p.totals.received.Add(1)
+	}
+	if !decoded {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.receivedKinds == nil {
+		p.receivedKinds = make(map[message.MessageType]uint64)
+	}
+	p.receivedKinds[kind]++
+}
+
+// RemoteAddr returns the network address of the client of the latest connection of the peer.
+func (p *Peer) RemoteAddr() string {
+	p.p.mu.Lock()
+	defer p.p.mu.Unlock()
+	return p.p.remoteAddr
+}
+
+// UserAgent returns the user agent of the client of the latest connection of the peer.
+func (p *Peer) UserAgent() string {
+	p.p.mu.Lock()
+	defer p.p.mu.Unlock()
+	return p.p.userAgent
+}
+
+// Connected reports whether the peer has a connection, false while it may still resume
+// its session.
+func (p *Peer) Connected() bool {
+	return p.p.current() != nil
+}
+
+// PeerMessageStats counts the messages of a peer.
+type PeerMessageStats struct {
+	// Messages read from the peer, including invalid ones
+	Received uint64 `json:"received"`
+	// Messages written to the peer
+	Sent uint64 `json:"sent"`
+	// Valid messages read from the peer by kind
+	ReceivedByKind map[string]uint64 `json:"receivedByKind"`
+}
+
+// MessageStats returns the message counters of the peer.
+func (p *Peer) MessageStats() PeerMessageStats {
+	stats := PeerMessageStats{
+		Received:       p.p.received.Load(),
+		Sent:           p.p.sent.Load(),
+		ReceivedByKind: make(map[string]uint64),
+	}
+	p.p.mu.Lock()
+	defer p.p.mu.Unlock()
+	for kind, count := range p.p.receivedKinds {
+		stats.ReceivedByKind[kind.String()] = count
+	}
+	return stats
+}
+
+// AdminConfig protects the admin API. A request must carry Token as a bearer token or,
+// when Authenticator is set, pass it instead. With neither, every request is rejected.
+type AdminConfig struct {
+	Token         string
+	Authenticator auth.Authenticator
+}
+
+// AdminPeer is a peer as listed by the admin API.
+type AdminPeer struct {
+	ID             string               `json:"id"`
+	Subject        string               `json:"subject,omitempty"`
+	RemoteAddr     string               `json:"remoteAddr"`
+	UserAgent      string               `json:"userAgent"`
+	ConnectedSince time.Time            `json:"connectedSince"`
+	Connected      bool                 `json:"connected"`
+	Rooms          []string             `json:"rooms"`
+	Metadata       message.PeerMetadata `json:"metadata"`
+	Messages       PeerMessageStats     `json:"messages"`
+}
+
+// AdminStats are the aggregate stats of the server.
+type AdminStats struct {
+	StartedAt        time.Time       `json:"startedAt"`
+	Peers            int             `json:"peers"`
+	Rooms            int             `json:"rooms"`
+	MessagesReceived uint64          `json:"messagesReceived"`
+	MessagesSent     uint64          `json:"messagesSent"`
+	RateLimits       *RateLimitStats `json:"rateLimits,omitempty"`
+}
+
+// AdminNotice is a notice the admin API broadcasts as a TextMessage from the server, to
+// the members of Room or to every peer if it is empty.
+type AdminNotice struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Room    string `json:"room,omitempty"`
+}
+
+// AdminHandler returns the admin API of the server, protected as config says:
+//
+//	GET  /peers            connected peers
+//	GET  /peers/{id}       one peer
+//	POST /peers/{id}/kick  disconnect a peer, with an optional {"reason": ...} body
+//	POST /broadcast        broadcast an AdminNotice
+//	GET  /stats            aggregate stats
+//
+// Mount it under a prefix with http.StripPrefix.
+func (s *SignalingServer) AdminHandler(config AdminConfig) http.Handler {
+	authenticator := config.Authenticator
+	if authenticator == nil {
+		authenticator = auth.BearerToken(config.Token)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers", s.adminListPeers)
+	mux.HandleFunc("GET /peers/{id}", s.adminGetPeer)
+	mux.HandleFunc("POST /peers/{id}/kick", s.adminKickPeer)
+	mux.HandleFunc("POST /broadcast", s.adminBroadcast)
+	mux.HandleFunc("GET /stats", s.adminStats)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := authenticator.Authenticate(r); err != nil {
+			s.logger.Warn("Rejected admin request", "remote_addr", r.RemoteAddr, "error", err)
+			status := auth.Status(err)
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			s.writeAdminError(w, status, err.Error())
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *SignalingServer) adminListPeers(w http.ResponseWriter, r *http.Request) {
+	peers := s.Peers()
+	list := make([]AdminPeer, len(peers))
+	for i, p := range peers {
+		list[i] = adminPeer(p)
+	}
+	slices.SortFunc(list, func(a, b AdminPeer) int {
+		return a.ConnectedSince.Compare(b.ConnectedSince)
+	})
+	s.writeAdminJSON(w, http.StatusOK, list)
+}
+
+func (s *SignalingServer) adminGetPeer(w http.ResponseWriter, r *http.Request) {
+	p, exist := s.Peer(r.PathValue("id"))
+	if !exist {
+		s.writeAdminError(w, http.StatusNotFound, ErrPeerNotFound.Error())
+		return
+	}
+	s.writeAdminJSON(w, http.StatusOK, adminPeer(p))
+}
+
+func (s *SignalingServer) adminKickPeer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.writeAdminError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+	if body.Reason == "" {
+		body.Reason = "disconnected by an administrator"
+	}
+	if err := s.Disconnect(r.PathValue("id"), body.Reason); err != nil {
+		if errors.Is(err, ErrPeerNotFound) {
+			s.writeAdminError(w, http.StatusNotFound, err.Error())
+		} else {
+			s.writeAdminError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *SignalingServer) adminBroadcast(w http.ResponseWriter, r *http.Request) {
+	var notice AdminNotice
+	if err := json.NewDecoder(r.Body).Decode(&notice); err != nil || notice.Message == "" {
+		s.writeAdminError(w, http.StatusBadRequest, "invalid notice")
+		return
+	}
+	content, err := json.Marshal(message.TextMessageContent{Title: notice.Title, Message: notice.Message})
+	if err != nil {
+		s.writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	msg := message.Message{Kind: message.TextMessage, Reach: message.AllPeers, Content: content}
+	var filter func(p *Peer) bool
+	if notice.Room != "" {
+		msg.Reach = message.Room
+		msg.Room = notice.Room
+		filter = func(p *Peer) bool {
+			return s.rooms.isMember(notice.Room, p.p)
+		}
+	}
+	sent := s.Broadcast(msg, filter)
+	s.writeAdminJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
+
+func (s *SignalingServer) adminStats(w http.ResponseWriter, r *http.Request) {
+	stats := AdminStats{
+		StartedAt:        s.startedAt,
+		Peers:            s.peers.len(),
+		Rooms:            len(s.rooms.names()),
+		MessagesReceived: s.messageTotals.received.Load(),
+		MessagesSent:     s.messageTotals.sent.Load(),
+	}
+	if s.rateLimits != nil {
+		rateLimits := s.RateLimitStats()
+		stats.RateLimits = &rateLimits
+	}
+	s.writeAdminJSON(w, http.StatusOK, stats)
+}
+
+func adminPeer(p *Peer) AdminPeer {
+	admin := AdminPeer{
+		ID:             p.ID(),
+		RemoteAddr:     p.RemoteAddr(),
+		UserAgent:      p.UserAgent(),
+		ConnectedSince: p.JoinedAt(),
+		Connected:      p.Connected(),
+		Rooms:          p.Rooms(),
+		Metadata:       p.Metadata(),
+		Messages:       p.MessageStats(),
+	}
+	if identity := p.Identity(); identity != nil {
+		admin.Subject = identity.Subject
+	}
+	if admin.Rooms == nil {
+		admin.Rooms = []string{}
+	}
+	return admin
+}
+
+func (s *SignalingServer) writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warn("Failed to write admin response", "error", err)
+	}
+}
+
+func (s *SignalingServer) writeAdminError(w http.ResponseWriter, status int, text string) {
+	s.writeAdminJSON(w, status, map[string]string{"error": text})
+}
+
+// writeFailed counts a message that could not be written to p.
+func (p *peer) writeFailed() {
+	if p.totals != nil {
+		p.totals.writeErrors.Add(1)
+	}
+}
@@ -0,0 +1,149 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// adminRequest serves a request of method to path with body, authorized with token
+// unless it is empty, and decodes the JSON response into v unless it is nil.
+func adminRequest(t *testing.T, handler http.Handler, method, path, token, body string, v any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body == "" {
+		r.ContentLength = 0
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if v != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %s: %v", method, path, w.Body, err)
+		}
+	}
+	return w.Code
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	handler := s.AdminHandler(AdminConfig{Token: "secret"})
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"guess", http.StatusForbidden},
+		{"secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		if status := adminRequest(t, handler, http.MethodGet, "/stats", test.token, "", nil); status != test.status {
+			t.Errorf("token %q: got status %d, want %d", test.token, status, test.status)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("401 without a WWW-Authenticate challenge")
+	}
+
+	if status := adminRequest(t, s.AdminHandler(AdminConfig{}), http.MethodGet, "/stats", "anything", "", nil); status != http.StatusForbidden {
+		t.Errorf("without token nor authenticator: got status %d, want 403", status)
+	}
+	custom := s.AdminHandler(AdminConfig{Token: "ignored", Authenticator: auth.AuthenticatorFunc(func(r *http.Request) (*auth.Identity, error) {
+		if r.Header.Get("X-Admin") != "yes" {
+			return nil, auth.Forbidden("not an admin")
+		}
+		return &auth.Identity{Subject: "oncall"}, nil
+	})})
+	r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	r.Header.Set("X-Admin", "yes")
+	w = httptest.NewRecorder()
+	custom.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("custom authenticator: got status %d, want 200", w.Code)
+	}
+	if status := adminRequest(t, custom, http.MethodGet, "/stats", "ignored", "", nil); status != http.StatusForbidden {
+		t.Errorf("token with a custom authenticator: got status %d, want 403", status)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithRateLimits(RateLimitConfig{}))
+	handler := s.AdminHandler(AdminConfig{Token: "secret"})
+	url := newTestServer(t, s)
+	header := http.Header{"User-Agent": {"test-agent"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestClient(t, conn)
+	b := dialLegacy(t, url)
+	idA, idB := a.identify(), b.identify()
+	a.joinRoom("r")
+
+	var peers []AdminPeer
+	if status := adminRequest(t, handler, http.MethodGet, "/peers", "secret", "", &peers); status != http.StatusOK || len(peers) != 2 {
+		t.Fatalf("listing peers: got status %d and %d peers, want 2", status, len(peers))
+	}
+	if peers[0].ID != idA || peers[1].ID != idB {
+		t.Errorf("listed %s and %s, want a then b", peers[0].ID, peers[1].ID)
+	}
+	var peer AdminPeer
+	if status := adminRequest(t, handler, http.MethodGet, "/peers/"+idA, "secret", "", &peer); status != http.StatusOK {
+		t.Fatalf("fetching a: got status %d", status)
+	}
+	if peer.UserAgent != "test-agent" || peer.RemoteAddr == "" || !peer.Connected || len(peer.Rooms) != 1 || peer.Rooms[0] != "r" ||
+		peer.Messages.Received != 2 || peer.Messages.ReceivedByKind["IdentifySelf"] != 1 || peer.Messages.Sent < 2 {
+		t.Errorf("fetched %+v, want a with its client, room and messages", peer)
+	}
+	if status := adminRequest(t, handler, http.MethodGet, "/peers/nobody", "secret", "", nil); status != http.StatusNotFound {
+		t.Errorf("fetching an unknown peer: got status %d, want 404", status)
+	}
+
+	var sent map[string]int
+	if status := adminRequest(t, handler, http.MethodPost, "/broadcast", "secret", `{"title":"notice","message":"maintenance","room":"r"}`, &sent); status != http.StatusOK || sent["sent"] != 1 {
+		t.Errorf("room notice: got status %d, sent %v, want it sent to a", status, sent)
+	}
+	msg := a.receiveKind(message.TextMessage)
+	var notice message.TextMessageContent
+	unmarshalContent(t, msg, &notice)
+	if msg.Sender != "server" || msg.Room != "r" || notice.Message != "maintenance" {
+		t.Errorf("a got %+v, want the notice", msg)
+	}
+	if status := adminRequest(t, handler, http.MethodPost, "/broadcast", "secret", `{"message":"everyone"}`, &sent); status != http.StatusOK || sent["sent"] != 2 {
+		t.Errorf("notice: got status %d, sent %v, want it sent to both peers", status, sent)
+	}
+	if status := adminRequest(t, handler, http.MethodPost, "/broadcast", "secret", `{"title":"empty"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("notice without message: got status %d, want 400", status)
+	}
+
+	var stats AdminStats
+	if status := adminRequest(t, handler, http.MethodGet, "/stats", "secret", "", &stats); status != http.StatusOK {
+		t.Fatalf("fetching stats: got status %d", status)
+	}
+	if stats.Peers != 2 || stats.Rooms != 1 || stats.MessagesReceived != 3 || stats.MessagesSent == 0 || stats.RateLimits == nil || stats.StartedAt.IsZero() {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	if status := adminRequest(t, handler, http.MethodPost, "/peers/"+idB+"/kick", "secret", "{", nil); status != http.StatusBadRequest {
+		t.Errorf("kick with an invalid body: got status %d, want 400", status)
+	}
+	if status := adminRequest(t, handler, http.MethodPost, "/peers/"+idB+"/kick", "secret", `{"reason":"bye"}`, nil); status != http.StatusNoContent {
+		t.Errorf("kicking b: got status %d, want 204", status)
+	}
+	if err := b.closed(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("b closed with %v, want a normal closure", err)
+	}
+	if status := adminRequest(t, handler, http.MethodPost, "/peers/nobody/kick", "secret", "", nil); status != http.StatusNotFound {
+		t.Errorf("kicking an unknown peer: got status %d, want 404", status)
+	}
+}
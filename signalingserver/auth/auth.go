@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// Identity is the verified identity of a connecting peer.
+type Identity struct {
+	// Subject identifies the authenticated user, e.g. the 'sub' claim of a JWT
+	Subject string
+
+	// Claims holds everything the authenticator verified about the request
+	Claims map[string]any
+}
+
+// Authenticator runs against the HTTP request before it is upgraded to a websocket connection.
+// Returning an error rejects the request; use *Error to control the HTTP status code.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// AuthenticatorFunc adapts a plain function to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// Error is an authentication failure with the HTTP status the request should be rejected with.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Status: http.StatusUnauthorized, Message: message}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Status: http.StatusForbidden, Message: message}
+}
+
+// Status returns the HTTP status code for an authentication error, defaulting to 401.
+func Status(err error) int {
+	var authErr *Error
+	if errors.As(err, &authErr) && authErr.Status != 0 {
+		return authErr.Status
+	}
+	return http.StatusUnauthorized
+}
+
+// BearerToken accepts the requests that carry token in a bearer Authorization header.
+// An empty token rejects every request.
+func BearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Identity, error) {
+		got := bearerToken(r)
+		if got == "" {
+			return nil, Unauthorized("missing token")
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return nil, Forbidden("invalid token")
+		}
+		return &Identity{}, nil
+	})
+}
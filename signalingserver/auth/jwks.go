@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// errUnsupportedKey is returned for keys of a type, curve or algorithm the verifier does not
+// support, which a JWKS shared with other services may well contain.
+var errUnsupportedKey = errors.New("unsupported key")
+
+// verificationKey is a public key from a JWKS file together with the algorithm it may verify.
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// loadJWKSFile reads the RSA and P-256 EC public keys of a local JWKS file.
+// Keys that are not meant for signature verification, or that the verifier does not
+// support, are skipped.
+func loadJWKSFile(path string) ([]verificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS file: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+	var keys []verificationKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, alg, err := jwk.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: %w", jwk.Kid, err)
+		}
+		if jwk.Alg != "" && !supportedAlgorithm(jwk.Alg) {
+			continue
+		}
+		if jwk.Alg != "" && jwk.Alg != alg {
+			return nil, fmt.Errorf("JWKS key %q: algorithm %s does not match key type %s", jwk.Kid, jwk.Alg, jwk.Kty)
+		}
+		keys = append(keys, verificationKey{kid: jwk.Kid, alg: alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no signature keys")
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, string, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, "", errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, "RS256", nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, "", fmt.Errorf("%w: curve %s", errUnsupportedKey, jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if len(x.Bytes()) > 32 || len(y.Bytes()) > 32 {
+			return nil, "", errors.New("invalid P-256 coordinates")
+		}
+		point := append([]byte{4}, append(x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32))...)...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, "", errors.New("point is not on curve P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, "ES256", nil
+	default:
+		return nil, "", fmt.Errorf("%w: type %s", errUnsupportedKey, jwk.Kty)
+	}
+}
+
+// supportedAlgorithm reports whether alg is one of the algorithms verified with JWKS keys.
+func supportedAlgorithm(alg string) bool {
+	return alg == "RS256" || alg == "ES256"
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
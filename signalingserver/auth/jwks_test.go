@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := rsaJWK("rsa-1", &keys.rsa.PublicKey)
+	ecKey := ecJWK("ec-1", &keys.ec.PublicKey)
+	okpKey := map[string]any{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	p384Key := map[string]any{"kty": "EC", "kid": "ec-384", "crv": "P-384", "x": encodeSegment(p384.X.Bytes()), "y": encodeSegment(p384.Y.Bytes())}
+	encryptionKey := rsaJWK("rsa-enc", &keys.rsa.PublicKey)
+	encryptionKey["use"] = "enc"
+	ps256Key := rsaJWK("rsa-pss", &keys.rsa.PublicKey)
+	ps256Key["alg"] = "PS256"
+	mismatchedKey := ecJWK("ec-rs256", &keys.ec.PublicKey)
+	mismatchedKey["alg"] = "RS256"
+	badModulusKey := rsaJWK("rsa-bad", &keys.rsa.PublicKey)
+	badModulusKey["n"] = "not base64!"
+	offCurveKey := ecJWK("ec-off", &keys.ec.PublicKey)
+	offCurveKey["y"] = offCurveKey["x"]
+
+	tests := []struct {
+		name string
+		keys []map[string]any
+		kids []string
+		fail bool
+	}{
+		{"RSA and EC", []map[string]any{rsaKey, ecKey}, []string{"rsa-1", "ec-1"}, false},
+		{"unsupported key types and curves are skipped", []map[string]any{okpKey, rsaKey, p384Key, ecKey}, []string{"rsa-1", "ec-1"}, false},
+		{"encryption keys are skipped", []map[string]any{encryptionKey, ecKey}, []string{"ec-1"}, false},
+		{"unsupported algorithms are skipped", []map[string]any{ps256Key, ecKey}, []string{"ec-1"}, false},
+		{"only unsupported keys", []map[string]any{okpKey, p384Key}, nil, true},
+		{"algorithm not matching the key type", []map[string]any{mismatchedKey}, nil, true},
+		{"malformed RSA key", []map[string]any{badModulusKey, ecKey}, nil, true},
+		{"point not on the curve", []map[string]any{offCurveKey}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(map[string]any{"keys": test.keys})
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := parseJWKS(data)
+			if test.fail {
+				if err == nil {
+					t.Fatalf("parsed %d keys, want an error", len(parsed))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kids []string
+			for _, key := range parsed {
+				kids = append(kids, key.kid)
+			}
+			if len(kids) != len(test.kids) {
+				t.Fatalf("parsed keys %v, want %v", kids, test.kids)
+			}
+			for i := range kids {
+				if kids[i] != test.kids[i] {
+					t.Fatalf("parsed keys %v, want %v", kids, test.kids)
+				}
+			}
+		})
+	}
+
+	if _, err := parseJWKS([]byte("{")); err == nil {
+		t.Error("malformed JWKS parsed")
+	}
+}
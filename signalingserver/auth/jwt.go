@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Default name of the query parameter a browser client can pass its token in,
+// since the WebSocket API in browsers cannot set an Authorization header.
+const DefaultTokenQueryParam = "access_token"
+
+type JWTConfig struct {
+	// Shared secret used to verify HS256 tokens
+	Secret []byte
+
+	// Path of a local JWKS file holding the public keys used to verify RS256 and ES256 tokens
+	JWKSFile string
+
+	// Expected 'iss' claim; not checked when empty
+	Issuer string
+
+	// Accepted 'aud' values; not checked when empty
+	Audience []string
+
+	// Clock skew tolerated when checking 'exp', 'nbf' and 'iat'
+	Leeway time.Duration
+
+	// Query parameter to read the token from when there is no Authorization header.
+	// Defaults to DefaultTokenQueryParam.
+	TokenQueryParam string
+}
+
+// JWTVerifier is an Authenticator that accepts requests carrying a valid JWT, either as
+// an 'Authorization: Bearer' header or in a query parameter.
+type JWTVerifier struct {
+	config JWTConfig
+	keys   []verificationKey
+}
+
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{config: config}
+	if len(config.Secret) == 0 && config.JWKSFile == "" {
+		return nil, errors.New("a JWT verifier needs a secret or a JWKS file")
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKSFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+	if v.config.TokenQueryParam == "" {
+		v.config.TokenQueryParam = DefaultTokenQueryParam
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		token = r.URL.Query().Get(v.config.TokenQueryParam)
+	}
+	if token == "" {
+		return nil, Unauthorized("missing token")
+	}
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	return &Identity{Subject: subject, Claims: claims}, nil
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature and registered claims of a compact-serialized JWT and returns its claims.
+// Numeric claims are returned as json.Number.
+func (v *JWTVerifier) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, Unauthorized("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, Unauthorized("malformed token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, Unauthorized("malformed token signature")
+	}
+	if err := v.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, Unauthorized("malformed token claims")
+	}
+	if err := v.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (v *JWTVerifier) verifySignature(header jwtHeader, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch header.Alg {
+	case "HS256":
+		if len(v.config.Secret) == 0 {
+			return Unauthorized("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, v.config.Secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return Unauthorized("invalid token signature")
+		}
+		return nil
+	case "RS256", "ES256":
+		for _, key := range v.keys {
+			if key.alg != header.Alg || (header.Kid != "" && key.kid != header.Kid) {
+				continue
+			}
+			if verifyWithKey(key.key, digest[:], signature) {
+				return nil
+			}
+		}
+		return Unauthorized("invalid token signature")
+	default:
+		return Unauthorized(fmt.Sprintf("unsupported token algorithm %q", header.Alg))
+	}
+}
+
+func verifyWithKey(key crypto.PublicKey, digest, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the concatenation of r and s
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func (v *JWTVerifier) verifyClaims(claims map[string]any) error {
+	now := time.Now()
+	leeway := v.config.Leeway
+
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return Unauthorized("token has no expiry")
+	}
+	if !now.Before(exp.Add(leeway)) {
+		return Unauthorized("token has expired")
+	}
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(leeway).Before(nbf) {
+		return Unauthorized("token is not valid yet")
+	}
+	iat, ok, err := numericDate(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(leeway).Before(iat) {
+		return Unauthorized("token was issued in the future")
+	}
+
+	if v.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+			return Unauthorized("unexpected token issuer")
+		}
+	}
+	if len(v.config.Audience) > 0 && !slices.ContainsFunc(audiences(claims["aud"]), func(aud string) bool {
+		return slices.Contains(v.config.Audience, aud)
+	}) {
+		return Unauthorized("unexpected token audience")
+	}
+	return nil
+}
+
+// numericDate reads a NumericDate claim, reporting whether it is present.
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	value, exist := claims[name]
+	if !exist {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, Unauthorized(fmt.Sprintf("invalid %q claim", name))
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, Unauthorized(fmt.Sprintf("invalid %q claim", name))
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)), true, nil
+}
+
+// audiences reads the 'aud' claim, which can be a single string or an array of strings.
+func audiences(value any) []string {
+	switch aud := value.(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		var auds []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// testKeys are the signing keys of the tests, whose public halves are in a JWKS file.
+type testKeys struct {
+	rsa      *rsa.PrivateKey
+	ec       *ecdsa.PrivateKey
+	jwksFile string
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &testKeys{rsa: rsaKey, ec: ecKey, jwksFile: filepath.Join(t.TempDir(), "jwks.json")}
+	writeJWKS(t, keys.jwksFile, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	return keys
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": encodeSegment(key.N.Bytes()),
+		"e": encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": encodeSegment(key.X.FillBytes(make([]byte, 32))),
+		"y": encodeSegment(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]any) {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sign returns a token with the given header and claims, signed as header["alg"] says.
+func (k *testKeys) sign(t *testing.T, header map[string]any, claims map[string]any) string {
+	t.Helper()
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := encodeSegment(headerJSON) + "." + encodeSegment(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch header["alg"] {
+	case "HS256":
+		mac := hmac.New(sha256.New, testSecret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + encodeSegment(signature)
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{"sub": "alice", "iss": "issuer", "aud": "app", "exp": now.Add(time.Minute).Unix()}
+	}
+	with := func(name string, value any) map[string]any {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	hs256 := map[string]any{"alg": "HS256"}
+	rs256 := map[string]any{"alg": "RS256", "kid": "rsa-1"}
+	es256 := map[string]any{"alg": "ES256", "kid": "ec-1"}
+
+	secretOnly := JWTConfig{Secret: testSecret, Issuer: "issuer", Audience: []string{"app"}}
+	jwksOnly := JWTConfig{JWKSFile: keys.jwksFile, Issuer: "issuer", Audience: []string{"app"}}
+	withLeeway := JWTConfig{Secret: testSecret, Leeway: time.Minute}
+
+	tests := []struct {
+		name   string
+		config JWTConfig
+		token  string
+		valid  bool
+	}{
+		{"HS256", secretOnly, keys.sign(t, hs256, valid()), true},
+		{"HS256 bad signature", JWTConfig{Secret: []byte("other-secret")}, keys.sign(t, hs256, valid()), false},
+		{"HS256 without secret", jwksOnly, keys.sign(t, hs256, valid()), false},
+		{"RS256", jwksOnly, keys.sign(t, rs256, valid()), true},
+		{"RS256 without kid", jwksOnly, keys.sign(t, map[string]any{"alg": "RS256"}, valid()), true},
+		{"RS256 kid mismatch", jwksOnly, keys.sign(t, map[string]any{"alg": "RS256", "kid": "rsa-2"}, valid()), false},
+		{"RS256 kid of the EC key", jwksOnly, keys.sign(t, map[string]any{"alg": "RS256", "kid": "ec-1"}, valid()), false},
+		{"RS256 without JWKS", secretOnly, keys.sign(t, rs256, valid()), false},
+		{"ES256", jwksOnly, keys.sign(t, es256, valid()), true},
+		{"ES256 kid mismatch", jwksOnly, keys.sign(t, map[string]any{"alg": "ES256", "kid": "rsa-1"}, valid()), false},
+		{"none algorithm", secretOnly, keys.sign(t, map[string]any{"alg": "none"}, valid()), false},
+		{"tampered claims", secretOnly, tamper(keys.sign(t, hs256, valid())), false},
+		{"malformed", secretOnly, "not-a-token", false},
+		{"expired", secretOnly, keys.sign(t, hs256, with("exp", now.Add(-time.Second).Unix())), false},
+		{"expired within leeway", withLeeway, keys.sign(t, hs256, with("exp", now.Add(-30*time.Second).Unix())), true},
+		{"expired beyond leeway", withLeeway, keys.sign(t, hs256, with("exp", now.Add(-2*time.Minute).Unix())), false},
+		{"missing exp", secretOnly, keys.sign(t, hs256, with("exp", nil)), false},
+		{"exp not a number", secretOnly, keys.sign(t, hs256, with("exp", "tomorrow")), false},
+		{"nbf in the future", secretOnly, keys.sign(t, hs256, with("nbf", now.Add(30*time.Second).Unix())), false},
+		{"nbf within leeway", withLeeway, keys.sign(t, hs256, with("nbf", now.Add(30*time.Second).Unix())), true},
+		{"nbf in the past", secretOnly, keys.sign(t, hs256, with("nbf", now.Add(-time.Second).Unix())), true},
+		{"iat in the future", secretOnly, keys.sign(t, hs256, with("iat", now.Add(30*time.Second).Unix())), false},
+		{"iat within leeway", withLeeway, keys.sign(t, hs256, with("iat", now.Add(30*time.Second).Unix())), true},
+		{"wrong iss", secretOnly, keys.sign(t, hs256, with("iss", "someone-else")), false},
+		{"missing iss", secretOnly, keys.sign(t, hs256, with("iss", nil)), false},
+		{"aud string mismatch", secretOnly, keys.sign(t, hs256, with("aud", "other-app")), false},
+		{"aud array", secretOnly, keys.sign(t, hs256, with("aud", []string{"other-app", "app"})), true},
+		{"aud array mismatch", secretOnly, keys.sign(t, hs256, with("aud", []string{"other-app"})), false},
+		{"missing aud", secretOnly, keys.sign(t, hs256, with("aud", nil)), false},
+		{"aud not checked", JWTConfig{Secret: testSecret}, keys.sign(t, hs256, with("aud", "anything")), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := NewJWTVerifier(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := v.Verify(test.token)
+			if !test.valid {
+				if err == nil {
+					t.Fatal("token accepted")
+				}
+				if status := Status(err); status != http.StatusUnauthorized {
+					t.Errorf("rejected with status %d, want %d", status, http.StatusUnauthorized)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("token rejected: %v", err)
+			}
+			if claims["sub"] != "alice" {
+				t.Errorf("sub claim is %v, want alice", claims["sub"])
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of token while keeping its signature.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	claims, _ := json.Marshal(map[string]any{"sub": "mallory", "exp": time.Now().Add(time.Hour).Unix()})
+	return parts[0] + "." + encodeSegment(claims) + "." + parts[2]
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+	v, err := NewJWTVerifier(JWTConfig{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := keys.sign(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "role": "host", "exp": time.Now().Add(time.Minute).Unix()})
+
+	header := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	header.Header.Set("Authorization", "Bearer "+token)
+	query := httptest.NewRequest(http.MethodGet, "/ws?"+DefaultTokenQueryParam+"="+token, nil)
+	for name, r := range map[string]*http.Request{"header": header, "query": query} {
+		identity, err := v.Authenticate(r)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if identity.Subject != "alice" || identity.Claims["role"] != "host" {
+			t.Errorf("%s: got identity %+v", name, identity)
+		}
+	}
+
+	_, err = v.Authenticate(httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if Status(err) != http.StatusUnauthorized {
+		t.Errorf("request without token: got %v, want 401", err)
+	}
+}
@@ -0,0 +1,229 @@
+package signalingserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// ErrAlreadySubscribed is returned by a Broker when a node subscribes twice.
+var ErrAlreadySubscribed = errors.New("node is already subscribed")
+
+// ClusterPeer is the record a Broker keeps of a peer connected to a node of the cluster.
+type ClusterPeer struct {
+	ID string `json:"id"`
+
+	// Node the peer is connected to
+	Node string `json:"node"`
+
+	// Rooms the peer is a member of. A peer in rooms is only visible to the other
+	// members of its rooms, one outside rooms to the other peers outside rooms.
+	Rooms []string `json:"rooms,omitempty"`
+}
+
+// EnvelopeType tells what a node asks of the nodes an Envelope is published to.
+type EnvelopeType string
+
+const (
+	// Deliver Message to its PeerID, and report the outcome to Peer on From
+	EnvelopeRelay EnvelopeType = "relay"
+	// Report is the outcome of the relayed message Message sent by Peer
+	EnvelopeReport EnvelopeType = "report"
+	// Deliver Message to the members of Rooms, or to the peers outside rooms
+	EnvelopeBroadcast EnvelopeType = "broadcast"
+)
+
+// Envelope is what the nodes of a cluster send each other through a Broker.
+type Envelope struct {
+	Type EnvelopeType `json:"type"`
+
+	// Node that published the envelope
+	From string `json:"from"`
+
+	// Node the envelope is for, empty for every other node
+	To string `json:"to,omitempty"`
+
+	// Peer of From that sent Message
+	Peer string `json:"peer,omitempty"`
+
+	Message message.Message `json:"message"`
+
+	// Rooms whose members a broadcast is for, empty for the peers outside rooms
+	Rooms []string `json:"rooms,omitempty"`
+
+	Report *DeliveryReport `json:"report,omitempty"`
+}
+
+// DeliveryReport tells the node of the sender of a relayed message what became of it.
+type DeliveryReport struct {
+	Status message.AckStatus `json:"status"`
+
+	// Whether the outcome is an error, which senders that did not ask for an ack get as
+	// an Error message with Code
+	Failed bool              `json:"failed,omitempty"`
+	Code   message.ErrorCode `json:"code"`
+
+	Text string `json:"text,omitempty"`
+
+	// Whether the message was held for an offline peer, which is acknowledged even to
+	// senders that did not ask for an ack
+	Held bool `json:"held,omitempty"`
+}
+
+// Broker connects the nodes of a cluster: it keeps the cluster-wide table of peers and
+// carries envelopes between nodes. A node stays a member of the cluster while it is
+// subscribed, and the peers of nodes that are not are left out of lookups.
+// Implementations must be safe for concurrent use.
+type Broker interface {
+	// Register claims the ID of peer for peer.Node, or updates the record of a peer the
+	// node already holds. It reports false if a peer of another live node holds the ID.
+	Register(ctx context.Context, peer ClusterPeer) (bool, error)
+
+	// Unregister releases the ID of peer, unless another node has since claimed it.
+	Unregister(ctx context.Context, peer ClusterPeer) error
+
+	// Lookup returns the record of the peer with the given ID.
+	Lookup(ctx context.Context, peerID string) (ClusterPeer, bool, error)
+
+	// Peers returns the records of the peers of every live node.
+	Peers(ctx context.Context) ([]ClusterPeer, error)
+
+	// Publish sends env to the node env.To, or to every other node when it is empty.
+	Publish(ctx context.Context, env Envelope) error
+
+	// Subscribe makes node a member of the cluster and calls handle, one envelope after
+	// the other, with the envelopes published to it, until ctx is canceled.
+	Subscribe(ctx context.Context, node string, handle func(Envelope)) error
+}
+
+// MemoryBroker is an in-process Broker, for several servers running in one process
+// such as in tests.
+type MemoryBroker struct {
+	mu    sync.Mutex
+	peers map[string]ClusterPeer
+	nodes map[string]*mailbox
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		peers: make(map[string]ClusterPeer),
+		nodes: make(map[string]*mailbox),
+	}
+}
+
+func (b *MemoryBroker) Register(ctx context.Context, peer ClusterPeer) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if current, exist := b.peers[peer.ID]; exist && current.Node != peer.Node && b.nodes[current.Node] != nil {
+		return false, nil
+	}
+	b.peers[peer.ID] = peer
+	return true, nil
+}
+
+func (b *MemoryBroker) Unregister(ctx context.Context, peer ClusterPeer) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.peers[peer.ID].Node == peer.Node {
+		delete(b.peers, peer.ID)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Lookup(ctx context.Context, peerID string) (ClusterPeer, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peer, exist := b.peers[peerID]
+	if !exist || b.nodes[peer.Node] == nil {
+		return ClusterPeer{}, false, nil
+	}
+	return peer, true, nil
+}
+
+func (b *MemoryBroker) Peers(ctx context.Context) ([]ClusterPeer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peers := make([]ClusterPeer, 0, len(b.peers))
+	for _, peer := range b.peers {
+		if b.nodes[peer.Node] != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, env Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if env.To != "" {
+		if box := b.nodes[env.To]; box != nil {
+			box.put(env)
+		}
+		return nil
+	}
+	for node, box := range b.nodes {
+		if node != env.From {
+			box.put(env)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, node string, handle func(Envelope)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.nodes[node] != nil {
+		return ErrAlreadySubscribed
+	}
+	box := &mailbox{ready: make(chan struct{}, 1)}
+	b.nodes[node] = box
+	go func() {
+		box.run(ctx, handle)
+		b.mu.Lock()
+		delete(b.nodes, node)
+		for id, peer := range b.peers {
+			if peer.Node == node {
+				delete(b.peers, id)
+			}
+		}
+		b.mu.Unlock()
+	}()
+	return nil
+}
+
+// mailbox queues the envelopes of a node, so publishers never wait for its handler.
+type mailbox struct {
+	mu        sync.Mutex
+	envelopes []Envelope
+	// signaled when envelopes are added
+	ready chan struct{}
+}
+
+func (m *mailbox) put(env Envelope) {
+	m.mu.Lock()
+	m.envelopes = append(m.envelopes, env)
+	m.mu.Unlock()
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailbox) run(ctx context.Context, handle func(Envelope)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.ready:
+		}
+		m.mu.Lock()
+		envelopes := m.envelopes
+		m.envelopes = nil
+		m.mu.Unlock()
+		for _, env := range envelopes {
+			handle(env)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package signalingserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/AbdelrahmanWM/signalingserver/utils"
+)
+
+// Default time limit of the broker calls made while handling a message
+const defaultClusterTimeout = 5 * time.Second
+
+// How long the server waits before subscribing again after it failed to
+const clusterRetryDelay = 5 * time.Second
+
+type ClusterConfig struct {
+	// Connects the nodes of the cluster
+	Broker Broker
+
+	// ID of the server in the cluster, unique among its nodes, random when empty
+	NodeID string
+
+	// Time limit of the broker calls made while handling a message, 5 seconds by default
+	Timeout time.Duration
+}
+
+// WithCluster makes the server one node of a cluster of servers sharing config.Broker,
+// typically replicas behind a load balancer. Peer IDs are then unique across the cluster,
+// OnePeer messages reach peers connected to any node, and rooms span every node:
+// GetAllPeerIDs, AllPeers and Room messages, room notifications, peer announcements and
+// disconnection notifications reach the peers of every node that share a room with the
+// sender, or that are outside rooms like it. GetPeers, peer list subscriptions and
+// store-and-forward stay local to each node.
+func WithCluster(config ClusterConfig) Option {
+	return func(s *SignalingServer) {
+		if config.NodeID == "" {
+			// crypto/rand does not fail on supported platforms
+			config.NodeID, _ = utils.RandomID(16)
+		}
+		if config.Timeout <= 0 {
+			config.Timeout = defaultClusterTimeout
+		}
+		s.cluster = &cluster{config: config}
+	}
+}
+
+// cluster is the state of a server that is a node of a cluster.
+type cluster struct {
+	config ClusterConfig
+
+	// ends the subscription of the node
+	cancel context.CancelFunc
+}
+
+// NodeID returns the ID of the server in its cluster, empty when it is not in one.
+func (s *SignalingServer) NodeID() string {
+	if s.cluster == nil {
+		return ""
+	}
+	return s.cluster.config.NodeID
+}
+
+// joinCluster subscribes the node to the envelopes of the other nodes, retrying until it
+// succeeds or the server shuts down.
+func (s *SignalingServer) joinCluster() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cluster.cancel = cancel
+	node := s.cluster.config.NodeID
+	logger := s.logger.With("node", node)
+	go func() {
+		for {
+			err := s.cluster.config.Broker.Subscribe(ctx, node, s.handleEnvelope)
+			if err == nil {
+				logger.Info("Joined the cluster")
+				return
+			}
+			logger.Error("Failed to join the cluster", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(clusterRetryDelay):
+			}
+		}
+	}()
+}
+
+// leaveCluster ends the subscription of the node, after which the other nodes no longer
+// see its peers.
+func (s *SignalingServer) leaveCluster() {
+	if s.cluster != nil {
+		s.cluster.cancel()
+	}
+}
+
+// brokerContext returns the context of a broker call.
+func (s *SignalingServer) brokerContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.cluster.config.Timeout)
+}
+
+// clusterPeer returns the record of the local peer p.
+func (s *SignalingServer) clusterPeer(p *peer) ClusterPeer {
+	rooms := s.rooms.roomsOf(p)
+	slices.Sort(rooms)
+	return ClusterPeer{ID: p.id, Node: s.cluster.config.NodeID, Rooms: rooms}
+}
+
+// claimPeer claims the ID of the newly registered p across the cluster, and reports false
+// if a peer of another node has it.
+func (s *SignalingServer) claimPeer(p *peer) (bool, error) {
+	if s.cluster == nil {
+		return true, nil
+	}
+	ctx, cancel := s.brokerContext()
+	defer cancel()
+	return s.cluster.config.Broker.Register(ctx, s.clusterPeer(p))
+}
+
+// updateClusterPeer tells the other nodes the rooms p is now a member of.
+func (s *SignalingServer) updateClusterPeer(p *peer) {
+	if s.cluster == nil {
+		return
+	}
+	ctx, cancel := s.brokerContext()
+	defer cancel()
+	if _, err := s.cluster.config.Broker.Register(ctx, s.clusterPeer(p)); err != nil {
+		p.logger.Error("Failed to update the peer in the cluster", "error", err)
+	}
+}
+
+// releasePeer frees the ID of p across the cluster.
+func (s *SignalingServer) releasePeer(p *peer) {
+	if s.cluster == nil {
+		return
+	}
+	ctx, cancel := s.brokerContext()
+	defer cancel()
+	if err := s.cluster.config.Broker.Unregister(ctx, ClusterPeer{ID: p.id, Node: s.cluster.config.NodeID}); err != nil {
+		p.logger.Error("Failed to unregister the peer from the cluster", "error", err)
+	}
+}
+
+// remotePeers returns the peers connected to the other nodes of the cluster.
+func (s *SignalingServer) remotePeers() ([]ClusterPeer, error) {
+	if s.cluster == nil {
+		return nil, nil
+	}
+	ctx, cancel := s.brokerContext()
+	defer cancel()
+	peers, err := s.cluster.config.Broker.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remote := peers[:0]
+	for _, peer := range peers {
+		if peer.Node != s.cluster.config.NodeID {
+			remote = append(remote, peer)
+		}
+	}
+	return remote, nil
+}
+
+// remotePeerIDs returns the IDs of the peers connected to the other nodes that are members
+// of any of rooms, or that are outside rooms when rooms is empty.
+func (s *SignalingServer) remotePeerIDs(rooms []string) ([]string, error) {
+	peers, err := s.remotePeers()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, peer := range peers {
+		if sharesRoom(peer.Rooms, rooms) {
+			ids = append(ids, peer.ID)
+		}
+	}
+	return ids, nil
+}
+
+// sharesRoom reports whether a peer in peerRooms is visible to the peers in rooms: they
+// have a room in common, or both are outside rooms.
+func sharesRoom(peerRooms, rooms []string) bool {
+	if len(rooms) == 0 {
+		return len(peerRooms) == 0
+	}
+	for _, room := range peerRooms {
+		if slices.Contains(rooms, room) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends env to the other nodes.
+func (s *SignalingServer) publish(env Envelope) error {
+	env.From = s.cluster.config.NodeID
+	ctx, cancel := s.brokerContext()
+	defer cancel()
+	return s.cluster.config.Broker.Publish(ctx, env)
+}
+
+// relayRemote sends the OnePeer message msg of p, as responseMsg, to the node its
+// recipient is connected to. It reports false if the recipient is not on another node.
+func (s *SignalingServer) relayRemote(p *peer, msg message.Message, responseMsg message.Message) bool {
+	if s.cluster == nil {
+		return false
+	}
+	ctx, cancel := s.brokerContext()
+	target, exist, err := s.cluster.config.Broker.Lookup(ctx, msg.PeerID)
+	cancel()
+	if err != nil {
+		p.logger.Error("Failed to look up peer in the cluster", "target", msg.PeerID, "error", err)
+		return false
+	}
+	if !exist || target.Node == s.cluster.config.NodeID {
+		return false
+	}
+	err = s.publish(Envelope{Type: EnvelopeRelay, To: target.Node, Peer: p.id, Message: responseMsg})
+	if err != nil {
+		p.logger.Error("Failed to relay message to another node", "target", msg.PeerID, "node", target.Node, "error", err)
+		s.reportDelivery(p, msg, DeliveryReport{Status: message.AckFailed, Failed: true, Code: message.ErrorInternal, Text: fmt.Sprintf("Failed to send message to peer %s", msg.PeerID)})
+	}
+	return true
+}
+
+// broadcastCluster sends msg, sent by the local peer sender or by the server when it is
+// empty, to the peers of the other nodes that are members of any of rooms, or that are
+// outside rooms when rooms is empty.
+func (s *SignalingServer) broadcastCluster(sender string, rooms []string, msg message.Message) {
+	if s.cluster == nil {
+		return
+	}
+	if err := s.publish(Envelope{Type: EnvelopeBroadcast, Peer: sender, Rooms: rooms, Message: msg}); err != nil {
+		s.logger.Error("Failed to broadcast message to the other nodes", "kind", msg.Kind, "error", err)
+	}
+}
+
+// handleEnvelope carries out what another node asks of this one.
+func (s *SignalingServer) handleEnvelope(env Envelope) {
+	switch env.Type {
+	case EnvelopeRelay:
+		msg := env.Message
+		msg.Reach = message.OnePeer
+		report := s.deliverLocal(env.Peer, msg, env.Message)
+		if msg.ID == "" && !report.Failed && !report.Held {
+			return
+		}
+		// the sender only needs to know which message the report is about
+		msg.Content = nil
+		if err := s.publish(Envelope{Type: EnvelopeReport, To: env.From, Peer: env.Peer, Message: msg, Report: &report}); err != nil {
+			s.logger.Error("Failed to report delivery to another node", "node", env.From, "error", err)
+		}
+	case EnvelopeReport:
+		if p, exist := s.peers.get(env.Peer); exist && env.Report != nil {
+			s.reportDelivery(p, env.Message, *env.Report)
+		}
+	case EnvelopeBroadcast:
+		counted := env.Message
+		if counted.Reach != message.Room {
+			counted.Reach = message.AllPeers
+		}
+		recipients := s.peersOutsideRooms()
+		if len(env.Rooms) > 0 {
+			recipients = s.roomMembers(env.Rooms)
+		}
+		for _, p := range recipients {
+			if err := s.send(p, env.Message); err != nil {
+				p.logger.Warn("Failed to relay message from another node", "kind", env.Message.Kind, "node", env.From, "error", err)
+				continue
+			}
+			// server notices are not relayed messages
+			if env.Peer != "" {
+				s.metrics.messageRelayed(counted)
+			}
+		}
+	default:
+		s.logger.Warn("Unexpected envelope type", "type", env.Type, "node", env.From)
+	}
+}
@@ -0,0 +1,127 @@
+package signalingserver
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// dialCluster connects a client to url with the features the cluster tests look at.
+func dialCluster(t *testing.T, url string) *testClient {
+	t.Helper()
+	return dialFeatures(t, url, message.FeatureRooms, message.FeatureErrors, message.FeatureAcks)
+}
+
+// sameIDs reports whether ids and want hold the same IDs, in any order.
+func sameIDs(ids []string, want ...string) bool {
+	ids, want = slices.Clone(ids), slices.Clone(want)
+	slices.Sort(ids)
+	slices.Sort(want)
+	return slices.Equal(ids, want)
+}
+
+func TestCluster(t *testing.T) {
+	broker := NewMemoryBroker()
+	s1 := NewSignalingServer(10, true, false, WithCluster(ClusterConfig{Broker: broker, NodeID: "n1"}))
+	s2 := NewSignalingServer(10, true, false, WithCluster(ClusterConfig{Broker: broker, NodeID: "n2"}))
+	if s1.NodeID() != "n1" || s2.NodeID() != "n2" {
+		t.Fatalf("node IDs %q and %q, want n1 and n2", s1.NodeID(), s2.NodeID())
+	}
+	url1, url2 := newTestServer(t, s1), newTestServer(t, s2)
+	a := dialCluster(t, url1)
+	b, c := dialCluster(t, url2), dialCluster(t, url2)
+	idA, idB, idC := a.identify(), b.identify(), c.identify()
+	// the peers of a node are only listed once it has subscribed
+	waitFor(t, "a to discover the peers of n2", func() bool { return sameIDs(a.peerIDs(""), idB, idC) })
+	waitFor(t, "b to discover the peers of n1", func() bool { return sameIDs(b.peerIDs(""), idA, idC) })
+
+	if ack := a.sendOffer(idB, "m1"); ack.Status != message.AckDelivered || ack.PeerID != idB {
+		t.Errorf("offer to a peer of another node acknowledged as %+v, want delivered", ack)
+	}
+	if msg := b.receiveKind(message.Offer); msg.Sender != idA || msg.ID != "m1" {
+		t.Errorf("b got %+v, want the offer of a", msg)
+	}
+	if ack := a.sendOffer("nobody", "m2"); ack.Status != message.AckUnknownPeer {
+		t.Errorf("offer to nobody acknowledged as %v, want unknown_peer", ack.Status)
+	}
+
+	c.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "all"})
+	if msg := a.receiveKind(message.TextMessage); msg.Sender != idC {
+		t.Errorf("a got %+v, want the broadcast of c", msg)
+	}
+	if msg := b.receiveKind(message.TextMessage); msg.Sender != idC {
+		t.Errorf("b got %+v, want the broadcast of c", msg)
+	}
+
+	b.joinRoom("r")
+	a.joinRoom("r")
+	var joined message.JoinRoomContent
+	unmarshalContent(t, b.receiveKind(message.JoinRoom), &joined)
+	if joined.Room != "r" || joined.PeerID != idA {
+		t.Errorf("b was told %+v, want a joining r", joined)
+	}
+	// nodes learn of the rooms of each other's peers after the JoinRoom answers
+	waitFor(t, "a to be listed in r", func() bool { return sameIDs(s2.GetRoomPeerIDs("r"), idA, idB) })
+	waitFor(t, "b to be listed in r", func() bool { return sameIDs(s1.GetRoomPeerIDs("r"), idA, idB) })
+	if ids := a.peerIDs(""); !sameIDs(ids, idB) {
+		t.Errorf("a discovers %v, want only b", ids)
+	}
+	if ids := c.peerIDs(""); len(ids) != 0 {
+		t.Errorf("peer outside rooms discovers %v, want none", ids)
+	}
+	if rooms := s1.GetRooms(); !slices.Equal(rooms, []string{"r"}) {
+		t.Errorf("n1 lists rooms %v, want [r]", rooms)
+	}
+
+	a.send(message.Message{Kind: message.TextMessage, Reach: message.Room, Room: "r", Content: []byte(`{"message":"room"}`)})
+	if msg := b.receive(); msg.Kind != message.TextMessage || msg.Reach != message.Room || msg.Room != "r" || msg.Sender != idA {
+		t.Errorf("b got %+v, want the room message of a", msg)
+	}
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "all in r"})
+	if msg := b.receive(); msg.Kind != message.TextMessage || msg.Sender != idA {
+		t.Errorf("b got %+v, want the broadcast of a", msg)
+	}
+	c.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "outside"})
+	c.expectNothing(100 * time.Millisecond)
+	a.expectNothing(100 * time.Millisecond)
+	b.expectNothing(100 * time.Millisecond)
+
+	b.sendContent(message.LeaveRoom, message.Self, message.LeaveRoomContent{Room: "r"})
+	b.receiveKind(message.LeaveRoom)
+	var left message.LeaveRoomContent
+	unmarshalContent(t, a.receiveKind(message.LeaveRoom), &left)
+	if left.Room != "r" || left.PeerID != idB {
+		t.Errorf("a was told %+v, want b leaving r", left)
+	}
+	a.sendContent(message.LeaveRoom, message.Self, message.LeaveRoomContent{Room: "r"})
+	a.receiveKind(message.LeaveRoom)
+	waitFor(t, "a to be outside rooms", func() bool { return sameIDs(c.peerIDs(""), idA, idB) })
+
+	a.Close()
+	for _, client := range []*testClient{b, c} {
+		if content := client.receiveDisconnection(); content.DisconnectedPeerID != idA {
+			t.Errorf("told %s disconnected, want a", content.DisconnectedPeerID)
+		}
+	}
+}
+
+func TestSharesRoom(t *testing.T) {
+	tests := []struct {
+		peerRooms, rooms []string
+		want             bool
+	}{
+		{nil, nil, true},
+		{[]string{"r"}, nil, false},
+		{nil, []string{"r"}, false},
+		{[]string{"r"}, []string{"r"}, true},
+		{[]string{"r1", "r2"}, []string{"r3", "r2"}, true},
+		{[]string{"r1"}, []string{"r2"}, false},
+	}
+	for _, test := range tests {
+		if got := sharesRoom(test.peerRooms, test.rooms); got != test.want {
+			t.Errorf("sharesRoom(%v, %v) = %v, want %v", test.peerRooms, test.rooms, got, test.want)
+		}
+	}
+}
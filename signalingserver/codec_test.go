@@ -0,0 +1,96 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// cborClient is a client speaking the CBOR codec. Unlike testClient, it reads in the
+// foreground, so a read that times out ends the test.
+type cborClient struct {
+	*websocket.Conn
+	t *testing.T
+}
+
+func dialCBOR(t *testing.T, url string) *cborClient {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{message.CBORSubprotocol}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if conn.Subprotocol() != message.CBORSubprotocol {
+		t.Fatalf("negotiated subprotocol %q, want %q", conn.Subprotocol(), message.CBORSubprotocol)
+	}
+	return &cborClient{Conn: conn, t: t}
+}
+
+func (c *cborClient) send(msg message.Message) {
+	c.t.Helper()
+	data, err := message.CBOR.Encode(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *cborClient) receive() message.Message {
+	c.t.Helper()
+	c.SetReadDeadline(time.Now().Add(receiveTimeout))
+	frameType, data, err := c.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("receiving: %v", err)
+	}
+	if frameType != websocket.BinaryMessage {
+		c.t.Fatalf("got a frame of type %d, want a binary one", frameType)
+	}
+	var msg message.Message
+	if err := message.CBOR.Decode(data, &msg); err != nil {
+		c.t.Fatalf("decoding %x: %v", data, err)
+	}
+	return msg
+}
+
+func TestCodecRelay(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	j := dial(t, url)
+	c := dialCBOR(t, url)
+	idJ := j.identify()
+	c.send(message.Message{Kind: message.IdentifySelf, Reach: message.Self, Content: json.RawMessage(`{}`)})
+	var identified message.IdentifySelfContent
+	unmarshalContent(t, c.receive(), &identified)
+
+	offer, _ := json.Marshal(message.OfferContent{Type: 1, SDP: "v=0"})
+	j.send(message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: identified.ID, Content: offer})
+	msg := c.receive()
+	var gotOffer message.OfferContent
+	unmarshalContent(t, msg, &gotOffer)
+	if msg.Kind != message.Offer || msg.Sender != idJ || gotOffer.SDP != "v=0" {
+		t.Errorf("CBOR client got %+v, want the offer of the JSON client", msg)
+	}
+
+	c.send(message.Message{Kind: message.Answer, Reach: message.OnePeer, PeerID: idJ, Content: json.RawMessage(`{"type":2,"sdp":"answer","extra":1.5}`)})
+	msg = j.receiveKind(message.Answer)
+	if msg.Sender != identified.ID || string(msg.Content) != `{"extra":1.5,"sdp":"answer","type":2}` {
+		t.Errorf("JSON client got %+v, want the answer of the CBOR client", msg)
+	}
+
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte{0xff}); err != nil {
+		t.Fatal(err)
+	}
+	// the client never sent Hello, so it gets the error as a text message
+	var content message.TextMessageContent
+	if msg := c.receive(); msg.Kind != message.TextMessage {
+		t.Errorf("got %v, want an error", msg.Kind)
+	} else if unmarshalContent(t, msg, &content); content.Title != "error" {
+		t.Errorf("got %+v, want an error", content)
+	}
+}
@@ -0,0 +1,206 @@
+package signalingserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// How long a test client waits for a message before giving up
+const receiveTimeout = 5 * time.Second
+
+var errReceiveTimeout = errors.New("no message received in time")
+
+// newTestServer serves s over HTTP until the test ends, and returns its websocket URL.
+func newTestServer(t *testing.T, s *SignalingServer) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleWebSocketConn))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// testClient is a websocket client of a test server. It reads in the background, so that
+// waiting for a message that does not come leaves the connection usable.
+type testClient struct {
+	*websocket.Conn
+	t *testing.T
+
+	messages chan message.Message
+	// closed once reading failed, with the error in err
+	done chan struct{}
+	err  error
+}
+
+// dialLegacy connects a client that never sends Hello, like the clients predating it.
+func dialLegacy(t *testing.T, url string) *testClient {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", url, err)
+	}
+	return newTestClient(t, conn)
+}
+
+func newTestClient(t *testing.T, conn *websocket.Conn) *testClient {
+	c := &testClient{Conn: conn, t: t, messages: make(chan message.Message, 1024), done: make(chan struct{})}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		defer close(c.done)
+		for {
+			var msg message.Message
+			if c.err = conn.ReadJSON(&msg); c.err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	return c
+}
+
+// dial connects a client speaking the latest protocol, with every feature.
+func dial(t *testing.T, url string) *testClient {
+	t.Helper()
+	return dialFeatures(t, url)
+}
+
+// dialFeatures connects a client speaking the latest protocol with the given features only,
+// so that it is not sent the messages of the others.
+func dialFeatures(t *testing.T, url string, features ...string) *testClient {
+	t.Helper()
+	c := dialLegacy(t, url)
+	c.sendContent(message.Hello, message.Self, message.HandshakeContent{Version: message.ProtocolVersion, Features: features})
+	c.receiveKind(message.Welcome)
+	return c
+}
+
+// send writes msg.
+func (c *testClient) send(msg message.Message) {
+	c.t.Helper()
+	if err := c.WriteJSON(msg); err != nil {
+		c.t.Fatalf("sending %v: %v", msg.Kind, err)
+	}
+}
+
+// sendContent writes a message of the given kind and reach, with content marshaled.
+func (c *testClient) sendContent(kind message.MessageType, reach message.ReachType, content any) {
+	c.t.Helper()
+	data, err := json.Marshal(content)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.send(message.Message{Kind: kind, Reach: reach, Content: data})
+}
+
+// next returns the next message, waiting at most timeout. It is safe to call from
+// any goroutine.
+func (c *testClient) next(timeout time.Duration) (message.Message, error) {
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-c.done:
+		select {
+		case msg := <-c.messages:
+			return msg, nil
+		default:
+			return message.Message{}, c.err
+		}
+	case <-time.After(timeout):
+		return message.Message{}, errReceiveTimeout
+	}
+}
+
+// receive returns the next message.
+func (c *testClient) receive() message.Message {
+	c.t.Helper()
+	msg, err := c.next(receiveTimeout)
+	if err != nil {
+		c.t.Fatalf("receiving: %v", err)
+	}
+	return msg
+}
+
+// receiveKind returns the next message of the given kind, skipping the others.
+func (c *testClient) receiveKind(kind message.MessageType) message.Message {
+	c.t.Helper()
+	for {
+		if msg := c.receive(); msg.Kind == kind {
+			return msg
+		}
+	}
+}
+
+// expectNothing fails the test if a message arrives within d.
+func (c *testClient) expectNothing(d time.Duration) {
+	c.t.Helper()
+	if msg, err := c.next(d); err == nil {
+		c.t.Fatalf("unexpected %v message: %s", msg.Kind, msg.Content)
+	}
+}
+
+// closed waits until the connection is closed, and returns the error that ended reading.
+func (c *testClient) closed() error {
+	c.t.Helper()
+	select {
+	case <-c.done:
+		return c.err
+	case <-time.After(receiveTimeout):
+		c.t.Fatal("connection was not closed")
+		return nil
+	}
+}
+
+// identify asks the server the ID of the client.
+func (c *testClient) identify() string {
+	c.t.Helper()
+	c.send(message.Message{Kind: message.IdentifySelf, Reach: message.Self})
+	var content message.IdentifySelfContent
+	unmarshalContent(c.t, c.receiveKind(message.IdentifySelf), &content)
+	return content.ID
+}
+
+// unmarshalContent decodes the content of msg into v.
+func unmarshalContent(t *testing.T, msg message.Message, v any) {
+	t.Helper()
+	if err := json.Unmarshal(msg.Content, v); err != nil {
+		t.Fatalf("decoding %v content %s: %v", msg.Kind, msg.Content, err)
+	}
+}
+
+// waitFor waits until cond holds, and fails the test if it does not within receiveTimeout.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(receiveTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// logBuffer collects the output of a logger, which can write from any goroutine.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
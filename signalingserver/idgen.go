@@ -0,0 +1,213 @@
+package signalingserver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/utils"
+)
+
+// Query parameter a client passes the peer ID it would like to get in
+const PreferredIDQueryParam = "peer_id"
+
+// Maximum length of a peer ID a client can ask for
+const maxPreferredIDLength = 128
+
+// Number of IDs tried before registration gives up on finding a free one
+const maxIDAttempts = 8
+
+var errNoFreeID = errors.New("no free peer ID found")
+
+// validPeerID matches the IDs a client can ask for
+var validPeerID = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+
+// IDGenerator generates peer IDs. The server retries with a new ID when one is already
+// taken, so generators do not need to check for collisions themselves.
+// Implementations must be safe for concurrent use.
+type IDGenerator interface {
+	GenerateID() (string, error)
+}
+
+// IDGeneratorFunc adapts a plain function to the IDGenerator interface.
+type IDGeneratorFunc func() (string, error)
+
+func (f IDGeneratorFunc) GenerateID() (string, error) {
+	return f()
+}
+
+// RandomIDGenerator generates alphanumeric IDs of length characters. It is the default,
+// with the ID length passed to NewSignalingServer.
+func RandomIDGenerator(length int) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		return utils.RandomID(length)
+	})
+}
+
+// UUIDv4Generator generates random UUIDs (version 4).
+func UUIDv4Generator() IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		var uuid [16]byte
+		if _, err := rand.Read(uuid[:]); err != nil {
+			return "", err
+		}
+		return formatUUID(uuid, 4), nil
+	})
+}
+
+// UUIDv7Generator generates time-ordered UUIDs (version 7), so IDs sort by creation time.
+func UUIDv7Generator() IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		var uuid [16]byte
+		if _, err := rand.Read(uuid[6:]); err != nil {
+			return "", err
+		}
+		putMillis(uuid[:6], time.Now())
+		return formatUUID(uuid, 7), nil
+	})
+}
+
+// ULIDGenerator generates ULIDs: 26 characters that sort by creation time.
+func ULIDGenerator() IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		var ulid [16]byte
+		if _, err := rand.Read(ulid[6:]); err != nil {
+			return "", err
+		}
+		putMillis(ulid[:6], time.Now())
+		return encodeULID(ulid), nil
+	})
+}
+
+// PrefixedIDGenerator prepends prefix to the IDs of generator, e.g. to namespace the IDs
+// of several servers or tenants.
+func PrefixedIDGenerator(prefix string, generator IDGenerator) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		id, err := generator.GenerateID()
+		if err != nil {
+			return "", err
+		}
+		return prefix + id, nil
+	})
+}
+
+// putMillis writes the Unix time of t in milliseconds to the 6 bytes of b, big endian.
+func putMillis(b []byte, t time.Time) {
+	var millis [8]byte
+	binary.BigEndian.PutUint64(millis[:], uint64(t.UnixMilli()))
+	copy(b, millis[2:])
+}
+
+func formatUUID(uuid [16]byte, version byte) string {
+	uuid[6] = uuid[6]&0x0f | version<<4
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 9562 variant
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf[:])
+}
+
+// Crockford's base32 alphabet used by ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeULID encodes the 128 bits of ulid as 26 base32 characters, most significant first.
+func encodeULID(ulid [16]byte) string {
+	hi := binary.BigEndian.Uint64(ulid[:8])
+	lo := binary.BigEndian.Uint64(ulid[8:])
+	var buf [26]byte
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = ulidAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// WithIDGenerator sets how the server generates peer IDs. The default is RandomIDGenerator
+// with the ID length passed to NewSignalingServer.
+func WithIDGenerator(generator IDGenerator) Option {
+	return func(s *SignalingServer) {
+		s.idGenerator = generator
+	}
+}
+
+// PreferredIDPolicy decides whether the client behind r, authenticated as identity (nil
+// without an authenticator), may get the peer ID it asked for.
+type PreferredIDPolicy func(r *http.Request, identity *auth.Identity, id string) bool
+
+// WithPreferredIDs lets clients ask for a peer ID in the PreferredIDQueryParam query
+// parameter. The ID is granted when policy allows it and no connected peer has it,
+// otherwise the client gets a generated one. Since messages held for an offline peer
+// are delivered to whoever next gets its ID, policy should only hand out an ID to the
+// client that owns it.
+func WithPreferredIDs(policy PreferredIDPolicy) Option {
+	return func(s *SignalingServer) {
+		s.preferredIDPolicy = policy
+	}
+}
+
+// AllowPreferredIDPatterns allows the preferred IDs matching any of patterns.
+func AllowPreferredIDPatterns(patterns ...*regexp.Regexp) PreferredIDPolicy {
+	return func(r *http.Request, identity *auth.Identity, id string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(id) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowSubjectAsID allows an authenticated client to use its subject as its peer ID.
+func AllowSubjectAsID() PreferredIDPolicy {
+	return func(r *http.Request, identity *auth.Identity, id string) bool {
+		return identity != nil && identity.Subject == id
+	}
+}
+
+// preferredID returns the peer ID the client behind r asked for, or "" if it did not
+// ask for one or may not have it.
+func (s *SignalingServer) preferredID(r *http.Request, identity *auth.Identity) string {
+	if s.preferredIDPolicy == nil {
+		return ""
+	}
+	id := r.URL.Query().Get(PreferredIDQueryParam)
+	if id == "" {
+		return ""
+	}
+	// "server" is the sender of the server's own messages
+	if len(id) > maxPreferredIDLength || id == "server" || !validPeerID.MatchString(id) || !s.preferredIDPolicy(r, identity, id) {
+		s.logger.Info("Preferred peer ID not allowed", "peer_id", id, "remote_addr", r.RemoteAddr)
+		return ""
+	}
+	return id
+}
+
+// nextID returns the ID to try for a new peer: the preferred ID on the first attempt,
+// generated ones after that.
+func (s *SignalingServer) nextID(preferredID string, attempt int) (string, error) {
+	if preferredID != "" && attempt == 0 {
+		return preferredID, nil
+	}
+	if attempt >= maxIDAttempts {
+		return "", errNoFreeID
+	}
+	id, err := s.idGenerator.GenerateID()
+	if err != nil {
+		return "", fmt.Errorf("generating peer ID: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,136 @@
+package signalingserver
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIDGenerators(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator IDGenerator
+		format    *regexp.Regexp
+		sortable  bool
+	}{
+		{"random", RandomIDGenerator(10), regexp.MustCompile(`^[A-Za-z0-9]{10}$`), false},
+		{"UUIDv4", UUIDv4Generator(), regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), false},
+		{"UUIDv7", UUIDv7Generator(), regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), true},
+		{"ULID", ULIDGenerator(), regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`), true},
+		{"prefixed", PrefixedIDGenerator("eu-", RandomIDGenerator(6)), regexp.MustCompile(`^eu-[A-Za-z0-9]{6}$`), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			previous := ""
+			for i := 0; i < 100; i++ {
+				id, err := test.generator.GenerateID()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !test.format.MatchString(id) {
+					t.Fatalf("ID %q does not match %s", id, test.format)
+				}
+				if seen[id] {
+					t.Fatalf("ID %q generated twice", id)
+				}
+				seen[id] = true
+				// IDs of different milliseconds sort by time
+				if test.sortable && i%10 == 0 {
+					if id <= previous {
+						t.Errorf("ID %q sorts before the earlier %q", id, previous)
+					}
+					time.Sleep(2 * time.Millisecond)
+					previous = id
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	var ulid [16]byte
+	if got := encodeULID(ulid); got != "00000000000000000000000000" {
+		t.Errorf("zero ULID encoded as %s", got)
+	}
+	for i := range ulid {
+		ulid[i] = 0xff
+	}
+	if got := encodeULID(ulid); got != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("maximal ULID encoded as %s", got)
+	}
+}
+
+// sequenceGenerator returns the given IDs in order, then errors.
+type sequenceGenerator struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (g *sequenceGenerator) GenerateID() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.ids) == 0 {
+		return "", errors.New("out of IDs")
+	}
+	id := g.ids[0]
+	g.ids = g.ids[1:]
+	return id, nil
+}
+
+func TestIDCollisionRetry(t *testing.T) {
+	generator := &sequenceGenerator{ids: []string{"same", "same", "same", "other"}}
+	s := NewSignalingServer(10, true, false, WithIDGenerator(generator))
+	url := newTestServer(t, s)
+	if id := dial(t, url).identify(); id != "same" {
+		t.Fatalf("first peer got ID %s, want same", id)
+	}
+	if id := dial(t, url).identify(); id != "other" {
+		t.Errorf("second peer got ID %s, want the first free one", id)
+	}
+
+	// registration gives up when the generator fails instead of exiting
+	c := dialLegacy(t, url)
+	c.closed()
+	if n := len(s.GetAllPeerIDs()); n != 2 {
+		t.Errorf("%d peers registered, want 2", n)
+	}
+}
+
+func TestIDCollisionAttempts(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithIDGenerator(IDGeneratorFunc(func() (string, error) { return "same", nil })))
+	url := newTestServer(t, s)
+	dial(t, url).identify()
+	c := dialLegacy(t, url)
+	c.closed()
+	if n := len(s.GetAllPeerIDs()); n != 1 {
+		t.Errorf("%d peers registered, want 1", n)
+	}
+}
+
+func TestPreferredIDs(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithIDGenerator(PrefixedIDGenerator("gen-", RandomIDGenerator(6))),
+		WithPreferredIDs(AllowPreferredIDPatterns(regexp.MustCompile(`^desk-`), regexp.MustCompile(`^server$`))))
+	url := newTestServer(t, s)
+	generated := regexp.MustCompile(`^gen-`)
+
+	if id := dial(t, url+"?"+PreferredIDQueryParam+"=desk-1").identify(); id != "desk-1" {
+		t.Errorf("allowed ID: got %s, want desk-1", id)
+	}
+	tests := []struct {
+		name      string
+		preferred string
+	}{
+		{"taken", "desk-1"},
+		{"not allowed", "alice"},
+		{"reserved", "server"},
+		{"invalid", "desk-1%2F2"},
+	}
+	for _, test := range tests {
+		if id := dial(t, url+"?"+PreferredIDQueryParam+"="+test.preferred).identify(); !generated.MatchString(id) {
+			t.Errorf("%s ID: got %s, want a generated one", test.name, id)
+		}
+	}
+}
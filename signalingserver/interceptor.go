@@ -0,0 +1,177 @@
+package signalingserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// Interceptor hooks into the life of every peer. Interceptors run in the order they were
+// added, and must be safe for concurrent use as they are called from the goroutine of
+// each connection.
+type Interceptor interface {
+	// OnConnect is called when a new peer registered, before other peers hear of it.
+	// Returning an error rejects the peer: its connection is closed with a policy violation.
+	OnConnect(p *Peer, r *http.Request) error
+
+	// OnMessage is called for every message a peer sends, before the server handles it.
+	// It can change the content, kind, reach, room or target peer ID of msg, and set its
+	// Sender, which is then what the recipients see. Returning ErrDropMessage drops the
+	// message silently, any other error rejects it (see RejectError). ctx is canceled
+	// when the connection closes.
+	OnMessage(ctx context.Context, p *Peer, msg *message.Message) error
+
+	// OnDisconnect is called once a peer OnConnect accepted is gone for good.
+	OnDisconnect(p *Peer, reason message.DisconnectReason)
+}
+
+// InterceptorFuncs adapts plain functions to the Interceptor interface. Nil functions do nothing.
+type InterceptorFuncs struct {
+	Connect    func(p *Peer, r *http.Request) error
+	Message    func(ctx context.Context, p *Peer, msg *message.Message) error
+	Disconnect func(p *Peer, reason message.DisconnectReason)
+}
+
+func (f InterceptorFuncs) OnConnect(p *Peer, r *http.Request) error {
+	if f.Connect == nil {
+		return nil
+	}
+	return f.Connect(p, r)
+}
+
+func (f InterceptorFuncs) OnMessage(ctx context.Context, p *Peer, msg *message.Message) error {
+	if f.Message == nil {
+		return nil
+	}
+	return f.Message(ctx, p, msg)
+}
+
+func (f InterceptorFuncs) OnDisconnect(p *Peer, reason message.DisconnectReason) {
+	if f.Disconnect != nil {
+		f.Disconnect(p, reason)
+	}
+}
+
+// ErrDropMessage makes OnMessage drop a message without telling the sender.
+var ErrDropMessage = errors.New("message dropped")
+
+// RejectError rejects a message with an Error of Code sent to the sender. Other errors
+// OnMessage returns are reported with message.ErrorRejected and the error text.
+type RejectError struct {
+	Code    message.ErrorCode
+	Message string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Reject returns a RejectError with code and text.
+func Reject(code message.ErrorCode, text string) error {
+	return &RejectError{Code: code, Message: text}
+}
+
+// WithInterceptors adds interceptors to the chain of the server.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(s *SignalingServer) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// Peer is a connected peer as seen by code embedding the server.
+type Peer struct {
+	server *SignalingServer
+	p      *peer
+}
+
+func (s *SignalingServer) handle(p *peer) *Peer {
+	return &Peer{server: s, p: p}
+}
+
+// ID returns the peer ID.
+func (p *Peer) ID() string {
+	return p.p.id
+}
+
+// Identity returns the verified identity of the peer, nil when the server has no authenticator.
+func (p *Peer) Identity() *auth.Identity {
+	return p.p.identity
+}
+
+// Metadata returns what the peer told others about itself.
+func (p *Peer) Metadata() message.PeerMetadata {
+	return p.p.getMetadata()
+}
+
+// JoinedAt returns when the peer registered.
+func (p *Peer) JoinedAt() time.Time {
+	return p.p.joinedAt
+}
+
+// Rooms returns the rooms the peer is a member of.
+func (p *Peer) Rooms() []string {
+	return p.server.rooms.roomsOf(p.p)
+}
+
+// interceptConnect runs OnConnect of the interceptors for the new peer p, and closes its
+// connection c if one rejects it.
+func (s *SignalingServer) interceptConnect(p *peer, c *peerConn, r *http.Request) bool {
+	handle := s.handle(p)
+	for _, interceptor := range s.interceptors {
+		if err := interceptor.OnConnect(handle, r); err != nil {
+			p.logger.Info("Interceptor rejected peer", "remote_addr", r.RemoteAddr, "error", err)
+			// the other peers never heard of it, and interceptors never saw it connect
+			p.markDisconnectNotified()
+			p.intercepted.Store(true)
+			p.kicked.Store(true)
+			c.closeWith(websocket.ClosePolicyViolation, "connection rejected")
+			<-c.pumpDone
+			s.unregisterPeer(p, message.DisconnectKicked)
+			return false
+		}
+	}
+	return true
+}
+
+// interceptMessage runs OnMessage of the interceptors on msg, and tells p if one of them
+// rejected it.
+func (s *SignalingServer) interceptMessage(ctx context.Context, p *peer, msg *message.Message) bool {
+	if len(s.interceptors) == 0 {
+		return true
+	}
+	handle := s.handle(p)
+	requestID := msg.ID
+	for _, interceptor := range s.interceptors {
+		err := interceptor.OnMessage(ctx, handle, msg)
+		if err == nil {
+			continue
+		}
+		var reject *RejectError
+		if errors.Is(err, ErrDropMessage) {
+			p.logger.Debug("Interceptor dropped message", "kind", msg.Kind)
+		} else if errors.As(err, &reject) {
+			s.sendError(p, requestID, reject.Code, reject.Message)
+		} else {
+			s.sendError(p, requestID, message.ErrorRejected, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// interceptDisconnect runs OnDisconnect of the interceptors, once per peer.
+func (s *SignalingServer) interceptDisconnect(p *peer, reason message.DisconnectReason) {
+	if !p.intercepted.CompareAndSwap(false, true) {
+		return
+	}
+	handle := s.handle(p)
+	for _, interceptor := range s.interceptors {
+		interceptor.OnDisconnect(handle, reason)
+	}
+}
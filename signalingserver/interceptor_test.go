@@ -0,0 +1,143 @@
+package signalingserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// eventRecorder is an interceptor that records the connections and disconnections it sees.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) record(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *eventRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func TestInterceptors(t *testing.T) {
+	recorder := &eventRecorder{}
+	interceptor := InterceptorFuncs{
+		Connect: func(p *Peer, r *http.Request) error {
+			if r.URL.Query().Get("deny") != "" {
+				return errors.New("denied")
+			}
+			recorder.record("connect %s", p.ID())
+			return nil
+		},
+		Message: func(ctx context.Context, p *Peer, msg *message.Message) error {
+			if msg.Kind != message.TextMessage {
+				return nil
+			}
+			var content message.TextMessageContent
+			json.Unmarshal(msg.Content, &content)
+			switch content.Title {
+			case "drop":
+				return ErrDropMessage
+			case "reject":
+				return errors.New("no thanks")
+			case "forbid":
+				return Reject(message.ErrorUnauthorized, "forbidden")
+			case "upper":
+				content.Message = strings.ToUpper(content.Message)
+				msg.Content, _ = json.Marshal(content)
+			case "redirect":
+				msg.Reach = message.Self
+			}
+			return nil
+		},
+		Disconnect: func(p *Peer, reason message.DisconnectReason) {
+			recorder.record("disconnect %s %v", p.ID(), reason)
+		},
+	}
+	s := NewSignalingServer(10, true, false, WithInterceptors(interceptor))
+	url := newTestServer(t, s)
+
+	denied := dialLegacy(t, url+"?deny=1")
+	if err := denied.closed(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("rejected peer closed with %v, want a policy violation", err)
+	}
+	a, b := dial(t, url), dial(t, url)
+	idA, idB := a.identify(), b.identify()
+
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Title: "drop"})
+	a.send(message.Message{ID: "r1", Kind: message.TextMessage, Reach: message.AllPeers, Content: json.RawMessage(`{"title":"reject"}`)})
+	if content := a.receiveError(); content.Code != message.ErrorRejected || content.Message != "no thanks" || content.RequestID != "r1" {
+		t.Errorf("got error %+v, want the rejection of r1", content)
+	}
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Title: "forbid"})
+	if content := a.receiveError(); content.Code != message.ErrorUnauthorized {
+		t.Errorf("got error %v, want %v", content.Code, message.ErrorUnauthorized)
+	}
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Title: "redirect"})
+	a.receiveKind(message.TextMessage)
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Title: "upper", Message: "hello"})
+	var content message.TextMessageContent
+	unmarshalContent(t, b.receiveKind(message.TextMessage), &content)
+	if content.Message != "HELLO" {
+		t.Errorf("b got %q, want the rewritten message", content.Message)
+	}
+	b.expectNothing(100 * time.Millisecond)
+
+	a.Close()
+	waitFor(t, "a to be unregistered", func() bool { return s.peers.len() == 1 })
+	want := []string{"connect " + idA, "connect " + idB, "disconnect " + idA + " " + message.DisconnectError.String()}
+	if events := recorder.recorded(); fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("recorded %v, want %v", events, want)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	logs := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s := NewSignalingServer(10, false, false, WithInterceptors(
+		LogInterceptor(logger),
+		MaxContentSize(100),
+		StampSender(func(p *Peer) string { return "user-" + p.ID() }),
+	))
+	url := newTestServer(t, s)
+	a, b := dial(t, url), dial(t, url)
+	idA := a.identify()
+	b.identify()
+
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: strings.Repeat("x", 200)})
+	if content := a.receiveError(); content.Code != message.ErrorTooLarge {
+		t.Errorf("got error %v, want %v", content.Code, message.ErrorTooLarge)
+	}
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "hi"})
+	if msg := b.receiveKind(message.TextMessage); msg.Sender != "user-"+idA {
+		t.Errorf("b got a message from %q, want the stamped sender", msg.Sender)
+	}
+	// answers from the server are still from the server
+	a.send(message.Message{Kind: message.IdentifySelf, Reach: message.Self})
+	if msg := a.receiveKind(message.IdentifySelf); msg.Sender != "server" {
+		t.Errorf("IdentifySelf answered by %q, want server", msg.Sender)
+	}
+
+	a.Close()
+	waitFor(t, "a to be unregistered", func() bool { return s.peers.len() == 1 })
+	for _, want := range []string{`"msg":"Peer connected","peer_id":"` + idA, `"msg":"Peer sent message","peer_id":"` + idA, `"msg":"Peer disconnected","peer_id":"` + idA} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs miss %s", want)
+		}
+	}
+}
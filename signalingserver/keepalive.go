@@ -0,0 +1,56 @@
+package signalingserver
+
+import "time"
+
+// KeepaliveConfig controls heartbeats and timeouts of peer connections.
+type KeepaliveConfig struct {
+	// Interval between pings sent to each peer, 0 disables pings.
+	// Must be shorter than PongWait.
+	PingInterval time.Duration
+
+	// Time a peer has to answer a ping (or send anything) before its connection is
+	// considered dead, 0 disables the read deadline
+	PongWait time.Duration
+
+	// Time allowed to write a single message to a peer
+	WriteWait time.Duration
+
+	// Peers that send no message for this long are evicted, 0 disables eviction.
+	// Pongs do not count as activity.
+	IdleTimeout time.Duration
+}
+
+func DefaultKeepaliveConfig() KeepaliveConfig {
+	return KeepaliveConfig{
+		PingInterval: 50 * time.Second,
+		PongWait:     60 * time.Second,
+		WriteWait:    10 * time.Second,
+	}
+}
+
+// readDeadline returns when the read deadline of a connection that last sent a message
+// at lastActivity should expire, or the zero time for no deadline.
+func (c KeepaliveConfig) readDeadline(now, lastActivity time.Time) time.Time {
+	var deadline time.Time
+	if c.PongWait > 0 {
+		deadline = now.Add(c.PongWait)
+	}
+	if c.IdleTimeout > 0 {
+		idleDeadline := lastActivity.Add(c.IdleTimeout)
+		if deadline.IsZero() || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	return deadline
+}
+
+// WithKeepalive configures heartbeats and timeouts of peer connections.
+// The default is DefaultKeepaliveConfig.
+func WithKeepalive(config KeepaliveConfig) Option {
+	return func(s *SignalingServer) {
+		if config.WriteWait <= 0 {
+			config.WriteWait = DefaultKeepaliveConfig().WriteWait
+		}
+		s.keepalive = config
+	}
+}
@@ -0,0 +1,97 @@
+package signalingserver
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestKeepaliveReadDeadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		config       KeepaliveConfig
+		lastActivity time.Time
+		want         time.Time
+	}{
+		{"no deadline", KeepaliveConfig{}, now, time.Time{}},
+		{"pong wait", KeepaliveConfig{PongWait: time.Minute}, now, now.Add(time.Minute)},
+		{"idle timeout", KeepaliveConfig{IdleTimeout: time.Minute}, now.Add(-10 * time.Second), now.Add(50 * time.Second)},
+		{"idle timeout first", KeepaliveConfig{PongWait: time.Minute, IdleTimeout: 2 * time.Minute}, now.Add(-90 * time.Second), now.Add(30 * time.Second)},
+		{"pong wait first", KeepaliveConfig{PongWait: time.Minute, IdleTimeout: 2 * time.Minute}, now, now.Add(time.Minute)},
+	}
+	for _, test := range tests {
+		if got := test.config.readDeadline(now, test.lastActivity); !got.Equal(test.want) {
+			t.Errorf("%s: got deadline %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestKeepalivePings(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithKeepalive(KeepaliveConfig{PingInterval: 20 * time.Millisecond, PongWait: 200 * time.Millisecond}))
+	url := newTestServer(t, s)
+	var pings atomic.Int32
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	c := newTestClient(t, conn)
+
+	// a client answering pings stays connected well past the pong wait
+	time.Sleep(500 * time.Millisecond)
+	if pings.Load() < 5 {
+		t.Errorf("got %d pings, want pings every 20ms", pings.Load())
+	}
+	if s.peers.len() != 1 {
+		t.Fatal("peer answering pings was evicted")
+	}
+	c.identify()
+}
+
+func TestKeepaliveEviction(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithKeepalive(KeepaliveConfig{PingInterval: 50 * time.Millisecond, PongWait: 150 * time.Millisecond, IdleTimeout: time.Second}))
+	url := newTestServer(t, s)
+
+	// never reads, so never answers pings
+	unresponsive, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unresponsive.Close()
+	waitFor(t, "the unresponsive peer to register", func() bool { return s.peers.len() == 1 })
+	start := time.Now()
+	waitFor(t, "the unresponsive peer to be evicted", func() bool { return s.peers.len() == 0 })
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Errorf("unresponsive peer evicted after %v, want about the pong wait", elapsed)
+	}
+
+	// answers pings but sends nothing
+	idle := dialLegacy(t, url)
+	start = time.Now()
+	err = idle.closed()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("idle peer disconnected with %v, want a policy violation close", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("idle peer evicted after %v, before the idle timeout", elapsed)
+	}
+	waitFor(t, "the idle peer to be unregistered", func() bool { return s.peers.len() == 0 })
+
+	// sending messages keeps a peer connected
+	active := dialLegacy(t, url)
+	for i := 0; i < 15; i++ {
+		time.Sleep(100 * time.Millisecond)
+		active.identify()
+	}
+	if s.peers.len() != 1 {
+		t.Error("active peer was evicted")
+	}
+}
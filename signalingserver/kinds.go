@@ -0,0 +1,82 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// KindHandler handles the messages of an application-defined kind (see message.RegisterKind).
+// It can answer the sender with r.Reply, and reports whether r.Message is then routed
+// according to its reach like any relayed message. Changing the reach, room or peer ID
+// of r.Message before returning true reroutes it.
+type KindHandler func(r *KindRequest) bool
+
+// KindRequest is a message of an application-defined kind received from a peer.
+type KindRequest struct {
+	// Message as sent by the peer, what gets routed if the handler returns true
+	Message message.Message
+
+	server *SignalingServer
+	peer   *peer
+}
+
+// PeerID returns the ID of the peer that sent the message.
+func (r *KindRequest) PeerID() string {
+	return r.peer.id
+}
+
+// Identity returns the verified identity of the sender, nil when the server has no authenticator.
+func (r *KindRequest) Identity() *auth.Identity {
+	return r.peer.identity
+}
+
+// Metadata returns the metadata of the sender.
+func (r *KindRequest) Metadata() message.PeerMetadata {
+	return r.peer.getMetadata()
+}
+
+// Reply sends the sender a message of kind with content marshaled to JSON, answering
+// the request ID of the message.
+func (r *KindRequest) Reply(kind message.MessageType, content any) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("marshaling reply: %w", err)
+	}
+	reply := message.Message{ID: r.Message.ID, Kind: kind, Reach: message.Self, Sender: "server", PeerID: r.peer.id, Content: data}
+	return r.server.send(r.peer, reply)
+}
+
+// Error answers the sender with an Error message.
+func (r *KindRequest) Error(code message.ErrorCode, text string) {
+	r.server.sendError(r.peer, r.Message.ID, code, text)
+}
+
+// WithKindHandler handles the messages of the application-defined kind on the server.
+// Messages of registered kinds without a handler are relayed according to their reach.
+func WithKindHandler(kind message.MessageType, handler KindHandler) Option {
+	return func(s *SignalingServer) {
+		if s.kindHandlers == nil {
+			s.kindHandlers = make(map[message.MessageType]KindHandler)
+		}
+		s.kindHandlers[kind] = handler
+	}
+}
+
+// handleCustomKind runs the handler of the kind of msg, if any, and returns the message
+// to route, or false if the handler consumed it.
+func (s *SignalingServer) handleCustomKind(p *peer, msg message.Message) (message.Message, bool) {
+	handler, exist := s.kindHandlers[msg.Kind]
+	if !exist {
+		return msg, true
+	}
+	request := &KindRequest{Message: msg, server: s, peer: p}
+	if !handler(request) {
+		return msg, false
+	}
+	// the request ID stays the sender's, whatever the handler did
+	request.Message.ID = msg.ID
+	return request.Message, true
+}
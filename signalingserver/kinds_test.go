@@ -0,0 +1,74 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+type echoContent struct {
+	Text   string `json:"text"`
+	PeerID string `json:"peerID,omitempty"`
+}
+
+// Kinds are registered once per process
+var (
+	echoKind = message.MustRegisterKind("test.echo", echoContent{})
+	chatKind = message.MustRegisterKind("test.chat", nil)
+)
+
+func TestKindHandlers(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithKindHandler(echoKind, func(r *KindRequest) bool {
+		var content echoContent
+		if err := json.Unmarshal(r.Message.Content, &content); err != nil {
+			r.Error(message.ErrorInvalidMessage, "invalid echo")
+			return false
+		}
+		switch content.Text {
+		case "relay":
+			r.Message.Reach = message.AllPeers
+			return true
+		case "reject":
+			r.Error(message.ErrorRejected, "rejected")
+			return false
+		}
+		if err := r.Reply(echoKind, echoContent{Text: content.Text, PeerID: r.PeerID()}); err != nil {
+			t.Errorf("replying: %v", err)
+		}
+		return false
+	}))
+	url := newTestServer(t, s)
+	a, b := dial(t, url), dial(t, url)
+	legacy := dialLegacy(t, url)
+	idA := a.identify()
+	b.identify()
+	legacy.identify()
+
+	a.send(message.Message{ID: "e1", Kind: echoKind, Reach: message.OnePeer, PeerID: "ignored", Content: json.RawMessage(`{"text":"hi"}`)})
+	reply := a.receiveKind(echoKind)
+	content, err := reply.UnmarshalContent()
+	if echo, ok := content.(echoContent); err != nil || !ok || echo.Text != "hi" || echo.PeerID != idA || reply.ID != "e1" {
+		t.Errorf("got reply %+v with content %#v, %v, want the echo of e1", reply, content, err)
+	}
+
+	a.send(message.Message{Kind: echoKind, Reach: message.Self, Content: json.RawMessage(`{"text":"relay"}`)})
+	if msg := b.receiveKind(echoKind); msg.Sender != idA || string(msg.Content) != `{"text":"relay"}` {
+		t.Errorf("b got %+v, want the rerouted echo of a", msg)
+	}
+
+	a.send(message.Message{ID: "e2", Kind: echoKind, Reach: message.AllPeers, Content: json.RawMessage(`{"text":"reject"}`)})
+	if content := a.receiveError(); content.Code != message.ErrorRejected || content.RequestID != "e2" {
+		t.Errorf("got error %+v, want the rejection of e2", content)
+	}
+
+	// kinds without a handler are relayed as they are
+	a.send(message.Message{Kind: chatKind, Reach: message.AllPeers, Content: json.RawMessage(`{"text":"hello"}`)})
+	if msg := b.receiveKind(chatKind); msg.Sender != idA || string(msg.Content) != `{"text":"hello"}` {
+		t.Errorf("b got %+v, want the chat message of a", msg)
+	}
+	b.expectNothing(100 * time.Millisecond)
+	// the kinds are unknown to clients that did not negotiate them
+	legacy.expectNothing(100 * time.Millisecond)
+}
@@ -0,0 +1,80 @@
+package signalingserver
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// LogConfig sets how the server logs. Records carry the attributes peer_id, kind, reach,
+// target (the peer ID a message is addressed to), room and remote_addr where they apply.
+type LogConfig struct {
+	// Logger receives the records of the server, slog.Default() when nil
+	Logger *slog.Logger
+
+	// Level is the minimum level of the records, in place of the one of the handler of
+	// Logger, nil to keep the handler's
+	Level slog.Leveler
+
+	// Debug logs message contents in full. Otherwise the SDP and ICE candidates in the
+	// content of Offer, Answer and ICECandidate messages are redacted.
+	Debug bool
+}
+
+// WithLogging sets how the server logs. The default is slog.Default() with SDP and ICE
+// candidates redacted.
+func WithLogging(config LogConfig) Option {
+	return func(s *SignalingServer) {
+		logger := config.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		if config.Level != nil {
+			logger = slog.New(&levelHandler{level: config.Level, Handler: logger.Handler()})
+		}
+		s.logger = logger
+		s.debugLogging = config.Debug
+	}
+}
+
+// levelHandler passes Handler the records at or above level, whatever level Handler has.
+type levelHandler struct {
+	level slog.Leveler
+	slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
+
+// Logged in place of redacted message contents
+const redacted = "[redacted]"
+
+// messageAttrs describes msg for a log record, with its content unless it is redacted.
+func (s *SignalingServer) messageAttrs(msg message.Message) []any {
+	attrs := []any{slog.Any("kind", msg.Kind), slog.Any("reach", msg.Reach)}
+	if msg.PeerID != "" {
+		attrs = append(attrs, slog.String("target", msg.PeerID))
+	}
+	if msg.Room != "" {
+		attrs = append(attrs, slog.String("room", msg.Room))
+	}
+	switch {
+	case s.debugLogging:
+		attrs = append(attrs, slog.String("content", string(msg.Content)))
+	case msg.Kind == message.Offer, msg.Kind == message.Answer, msg.Kind == message.ICECandidate:
+		attrs = append(attrs, slog.String("content", redacted))
+	default:
+		attrs = append(attrs, slog.String("content", string(msg.Content)))
+	}
+	return attrs
+}
@@ -0,0 +1,82 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// logRecords decodes the JSON records in logs.
+func logRecords(t *testing.T, logs *logBuffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decoding log record %s: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggingRedaction(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		logs := &logBuffer{}
+		// the handler would drop debug records without Level
+		logger := slog.New(slog.NewJSONHandler(logs, nil))
+		s := NewSignalingServer(10, true, false, WithLogging(LogConfig{Logger: logger, Level: slog.LevelDebug, Debug: debug}))
+		url := newTestServer(t, s)
+		a, b := dial(t, url), dial(t, url)
+		idB := b.identify()
+		a.send(message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: idB, Content: json.RawMessage(`{"sdp":"v=0 secret"}`)})
+		a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "public"})
+		b.receiveKind(message.Offer)
+		b.receiveKind(message.TextMessage)
+
+		contents := make(map[string]any)
+		for _, record := range logRecords(t, logs) {
+			if record["msg"] == "Message received" {
+				if record["peer_id"] == nil || record["reach"] == nil {
+					t.Errorf("record %v misses peer_id or reach", record)
+				}
+				if record["kind"] == "Offer" && record["target"] != idB {
+					t.Errorf("record %v has target %v, want %s", record, record["target"], idB)
+				}
+				contents[record["kind"].(string)] = record["content"]
+			}
+		}
+		wantOffer := redacted
+		if debug {
+			wantOffer = `{"sdp":"v=0 secret"}`
+		}
+		if contents["Offer"] != wantOffer {
+			t.Errorf("debug %v: offer logged with content %v, want %s", debug, contents["Offer"], wantOffer)
+		}
+		if content, _ := contents["TextMessage"].(string); !strings.Contains(content, "public") {
+			t.Errorf("debug %v: text message logged with content %v", debug, contents["TextMessage"])
+		}
+	}
+}
+
+func TestLoggingLevel(t *testing.T) {
+	logs := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s := NewSignalingServer(10, true, false, WithLogging(LogConfig{Logger: logger, Level: slog.LevelWarn}))
+	url := newTestServer(t, s)
+	c := dial(t, url)
+	c.identify()
+	c.Close()
+	waitFor(t, "the peer to be unregistered", func() bool { return s.peers.len() == 0 })
+	for _, record := range logRecords(t, logs) {
+		if record["level"] != "WARN" && record["level"] != "ERROR" {
+			t.Errorf("logged %v below the configured level", record)
+		}
+	}
+}
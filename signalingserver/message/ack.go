@@ -0,0 +1,191 @@
+package message
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AckStatus tells a sender what became of a OnePeer message it gave an ID.
+type AckStatus int
+
+const (
+	// The message was handed to the connection of the target peer
+	AckDelivered AckStatus = iota
+	// The target is offline, the message is held until it connects again
+	AckQueued
+	// No peer has the target ID
+	AckUnknownPeer
+	// The message could not be delivered nor held
+	AckFailed
+)
+
+func (s AckStatus) MarshalJSON() ([]byte, error) {
+	if s < AckDelivered || s > AckFailed {
+		return nil, fmt.Errorf("unknown AckStatus %d", s)
+	}
+	return json.Marshal(s.String())
+}
+
+func (s *AckStatus) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	switch str {
+	case "delivered":
+		*s = AckDelivered
+	case "queued":
+		*s = AckQueued
+	case "unknown_peer":
+		*s = AckUnknownPeer
+	case "failed":
+		*s = AckFailed
+	default:
+		return fmt.Errorf("unknown AckStatus string %s", str)
+	}
+	return nil
+}
+
+func (s AckStatus) String() string {
+	switch s {
+	case AckDelivered:
+		return "delivered"
+	case AckQueued:
+		return "queued"
+	case AckUnknownPeer:
+		return "unknown_peer"
+	case AckFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("AckStatus(%d)", int(s))
+	}
+}
+
+// The server answers every OnePeer message that has an ID with an Ack. MessageID is
+// that ID and PeerID the target of the message.
+type AckContent struct {
+	MessageID string    `json:"messageID"`
+	Status    AckStatus `json:"status"`
+	PeerID    string    `json:"peerID,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// ErrAckTimeout is returned by PendingAck.Wait when no answer came in time.
+var ErrAckTimeout = errors.New("timed out waiting for ack")
+
+// RequestError is returned by PendingAck.Wait when the server answered the message
+// with an Error instead of an Ack.
+type RequestError struct {
+	ErrorContent
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewMessageID returns a random message ID.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// IDs only need to be unique among the messages a client waits on
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// AckTracker lets a client wait for the Ack of the OnePeer messages it sends, the only
+// ones the server acknowledges. Pass every message received from the server to Handle.
+type AckTracker struct {
+	mu      sync.Mutex
+	pending map[string]*PendingAck
+}
+
+func NewAckTracker() *AckTracker {
+	return &AckTracker{pending: make(map[string]*PendingAck)}
+}
+
+// PendingAck is the answer to one message the client is waiting for.
+type PendingAck struct {
+	tracker *AckTracker
+	id      string
+	done    chan struct{}
+	ack     AckContent
+	err     error
+}
+
+// Expect starts tracking the message with ID id. Call it before sending the message so
+// a fast answer is not missed.
+func (t *AckTracker) Expect(id string) *PendingAck {
+	pending := &PendingAck{tracker: t, id: id, done: make(chan struct{})}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[id] = pending
+	return pending
+}
+
+// Handle resolves the pending message msg answers, if any, and reports whether msg
+// was such an answer: an Ack, or an Error about a tracked request.
+func (t *AckTracker) Handle(msg Message) bool {
+	var id string
+	var ack AckContent
+	var err error
+	switch msg.Kind {
+	case Ack:
+		if json.Unmarshal(msg.Content, &ack) != nil {
+			return false
+		}
+		id = ack.MessageID
+	case Error:
+		var errorContent ErrorContent
+		if json.Unmarshal(msg.Content, &errorContent) != nil || errorContent.RequestID == "" {
+			return false
+		}
+		id = errorContent.RequestID
+		err = &RequestError{errorContent}
+	default:
+		return false
+	}
+	t.mu.Lock()
+	pending, exist := t.pending[id]
+	delete(t.pending, id)
+	t.mu.Unlock()
+	if !exist {
+		return false
+	}
+	pending.ack = ack
+	pending.err = err
+	close(pending.done)
+	return true
+}
+
+// Wait returns the Ack of the message, a *RequestError if the server rejected it, or
+// ErrAckTimeout if no answer came within timeout.
+func (p *PendingAck) Wait(timeout time.Duration) (AckContent, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return p.ack, p.err
+	case <-timer.C:
+		p.tracker.mu.Lock()
+		_, waiting := p.tracker.pending[p.id]
+		delete(p.tracker.pending, p.id)
+		p.tracker.mu.Unlock()
+		if waiting {
+			return AckContent{}, ErrAckTimeout
+		}
+		// the answer is being handled right now
+		<-p.done
+		return p.ack, p.err
+	}
+}
+
+// Done is closed once the answer to the message arrived.
+func (p *PendingAck) Done() <-chan struct{} {
+	return p.done
+}
@@ -0,0 +1,76 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustMessage(t *testing.T, kind MessageType, content any) Message {
+	t.Helper()
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Message{Kind: kind, Reach: Self, Content: data}
+}
+
+func TestAckTracker(t *testing.T) {
+	tracker := NewAckTracker()
+	delivered := tracker.Expect("m1")
+	rejected := tracker.Expect("m2")
+	lost := tracker.Expect("m3")
+
+	if !tracker.Handle(mustMessage(t, Ack, AckContent{MessageID: "m1", Status: AckDelivered, PeerID: "bob"})) {
+		t.Error("ack of a tracked message not handled")
+	}
+	if !tracker.Handle(mustMessage(t, Error, ErrorContent{Code: ErrorRateLimited, Message: "slow down", RequestID: "m2"})) {
+		t.Error("error about a tracked message not handled")
+	}
+	if tracker.Handle(mustMessage(t, Ack, AckContent{MessageID: "m1"})) {
+		t.Error("second ack of a message handled")
+	}
+	if tracker.Handle(mustMessage(t, Error, ErrorContent{Code: ErrorInvalidMessage})) {
+		t.Error("error without request ID handled")
+	}
+	if tracker.Handle(mustMessage(t, TextMessage, TextMessageContent{Message: "m3"})) {
+		t.Error("text message handled")
+	}
+
+	select {
+	case <-delivered.Done():
+	default:
+		t.Error("Done not closed once the ack arrived")
+	}
+	if ack, err := delivered.Wait(time.Second); err != nil || ack.Status != AckDelivered || ack.PeerID != "bob" {
+		t.Errorf("m1: got %+v, %v, want delivered to bob", ack, err)
+	}
+	var requestErr *RequestError
+	if _, err := rejected.Wait(time.Second); !errors.As(err, &requestErr) || requestErr.Code != ErrorRateLimited {
+		t.Errorf("m2: got %v, want the rate limit error", err)
+	}
+	if _, err := lost.Wait(10 * time.Millisecond); !errors.Is(err, ErrAckTimeout) {
+		t.Errorf("m3: got %v, want ErrAckTimeout", err)
+	}
+	if tracker.Handle(mustMessage(t, Ack, AckContent{MessageID: "m3"})) {
+		t.Error("ack of a timed out message handled")
+	}
+}
+
+func TestAckStatusJSON(t *testing.T) {
+	for status := AckDelivered; status <= AckFailed; status++ {
+		data, err := json.Marshal(status)
+		if err != nil {
+			t.Fatalf("marshaling %v: %v", status, err)
+		}
+		var decoded AckStatus
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != status {
+			t.Errorf("%s decoded as %v, %v", data, decoded, err)
+		}
+	}
+	var status AckStatus
+	if err := json.Unmarshal([]byte(`"lost"`), &status); err == nil {
+		t.Error("unmarshaled an unknown status")
+	}
+}
@@ -0,0 +1,125 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec encodes messages on the wire. A client picks one by offering its Name as a
+// websocket subprotocol in the Sec-WebSocket-Protocol header; JSON is used when it offers none.
+type Codec interface {
+	// Subprotocol name of the codec
+	Name() string
+
+	// Binary reports whether encoded messages are sent as binary rather than text frames.
+	Binary() bool
+
+	Encode(msg Message) ([]byte, error)
+	Decode(data []byte, msg *Message) error
+}
+
+// Subprotocol names of the built-in codecs
+const (
+	JSONSubprotocol = "signalingserver.json"
+	CBORSubprotocol = "signalingserver.cbor"
+)
+
+var (
+	// JSON is the text encoding every client understands.
+	JSON Codec = jsonCodec{}
+
+	// CBOR is a compact binary encoding (RFC 8949) of the same structure as JSON: the
+	// envelope and content are CBOR maps with the keys and values of their JSON encoding.
+	CBOR Codec = cborCodec{}
+)
+
+// Codecs lists the built-in codecs in order of preference.
+var Codecs = []Codec{CBOR, JSON}
+
+// CodecByName returns the built-in codec with subprotocol name.
+func CodecByName(name string) (Codec, bool) {
+	for _, codec := range Codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return JSONSubprotocol }
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Encode(msg Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte, msg *Message) error {
+	return json.Unmarshal(data, msg)
+}
+
+// cborCodec transcodes the JSON encoding of messages, so enums keep their string names
+// and content needs no CBOR specific definitions.
+type cborCodec struct{}
+
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+}.DecMode()
+
+func (cborCodec) Name() string { return CBORSubprotocol }
+func (cborCodec) Binary() bool { return true }
+
+func (cborCodec) Encode(msg Message) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(cborNumbers(value))
+}
+
+func (cborCodec) Decode(data []byte, msg *Message) error {
+	var value any
+	if err := cborDecMode.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if _, isMap := value.(map[string]any); !isMap {
+		return fmt.Errorf("CBOR message is a %T, not a map", value)
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, msg)
+}
+
+// cborNumbers replaces the JSON numbers in value by integers where possible, floats otherwise.
+func cborNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = cborNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = cborNumbers(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package message
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCodecs(t *testing.T) {
+	msg := Message{
+		ID: "m1", Kind: Offer, Reach: OnePeer, Sender: "alice", PeerID: "bob",
+		Content: json.RawMessage(`{"type":1,"sdp":"v=0","ratio":1.5,"big":9007199254740993,"codecs":["vp8",{"pt":96}],"muted":false,"none":null}`),
+	}
+	for _, codec := range Codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			if found, ok := CodecByName(codec.Name()); !ok || found != codec {
+				t.Errorf("CodecByName(%q) = %v, %v", codec.Name(), found, ok)
+			}
+			data, err := codec.Encode(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Message
+			if err := codec.Decode(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.ID != msg.ID || decoded.Kind != msg.Kind || decoded.Reach != msg.Reach || decoded.Sender != msg.Sender || decoded.PeerID != msg.PeerID {
+				t.Errorf("decoded envelope %+v, want %+v", decoded, msg)
+			}
+			var want, got any
+			json.Unmarshal(msg.Content, &want)
+			if err := json.Unmarshal(decoded.Content, &got); err != nil {
+				t.Fatalf("decoded content %s: %v", decoded.Content, err)
+			}
+			wantJSON, _ := json.Marshal(want)
+			gotJSON, _ := json.Marshal(got)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("decoded content %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+	if _, ok := CodecByName("signalingserver.msgpack"); ok {
+		t.Error("found a codec that does not exist")
+	}
+}
+
+func TestCBORKeepsIntegers(t *testing.T) {
+	data, err := CBOR.Encode(Message{Kind: Offer, Reach: OnePeer, Content: json.RawMessage(`{"big":9007199254740993}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value map[string]any
+	if err := cbor.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := value["content"].(map[any]any)
+	if big, ok := content["big"].(uint64); !ok || big != 9007199254740993 {
+		t.Errorf("big is encoded as %T %v, want the exact integer", content["big"], content["big"])
+	}
+}
+
+func TestCBORDecodeErrors(t *testing.T) {
+	notMap, _ := cbor.Marshal([]string{"Offer"})
+	for name, data := range map[string][]byte{"truncated": {0xa1}, "invalid": {0xff}, "not a map": notMap} {
+		var msg Message
+		if err := CBOR.Decode(data, &msg); err == nil {
+			t.Errorf("%s: decoded %+v", name, msg)
+		}
+	}
+}
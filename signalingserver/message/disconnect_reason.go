@@ -0,0 +1,62 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DisconnectReason tells peers why another peer went away.
+type DisconnectReason int
+
+const (
+	// The peer disconnected on purpose, by a Disconnect message or a normal close
+	DisconnectGraceful DisconnectReason = iota
+	// The peer stopped answering pings or stayed idle for too long
+	DisconnectTimeout
+	// The connection failed
+	DisconnectError
+	// The server disconnected the peer
+	DisconnectKicked
+)
+
+func (r DisconnectReason) MarshalJSON() ([]byte, error) {
+	if r < DisconnectGraceful || r > DisconnectKicked {
+		return nil, fmt.Errorf("unknown DisconnectReason %d", r)
+	}
+	return json.Marshal(r.String())
+}
+
+func (r *DisconnectReason) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	switch s {
+	case "graceful":
+		*r = DisconnectGraceful
+	case "timeout":
+		*r = DisconnectTimeout
+	case "error":
+		*r = DisconnectError
+	case "kicked":
+		*r = DisconnectKicked
+	default:
+		return fmt.Errorf("unknown DisconnectReason string %s", s)
+	}
+	return nil
+}
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectGraceful:
+		return "graceful"
+	case DisconnectTimeout:
+		return "timeout"
+	case DisconnectError:
+		return "error"
+	case DisconnectKicked:
+		return "kicked"
+	default:
+		return fmt.Sprintf("DisconnectReason(%d)", int(r))
+	}
+}
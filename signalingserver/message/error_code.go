@@ -0,0 +1,110 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ErrorCode tells a peer why the server could not handle its request.
+type ErrorCode int
+
+const (
+	// The message is not valid JSON or does not have the expected content
+	ErrorInvalidMessage ErrorCode = iota
+	// The message kind is unknown or not one a peer can send
+	ErrorUnknownKind
+	// The reach type is unknown
+	ErrorUnknownReach
+	// No peer has the target ID
+	ErrorUnknownPeer
+	// The peer is not a member of the room it addressed
+	ErrorNotInRoom
+	// The peer already is a member of the room it tried to join
+	ErrorAlreadyInRoom
+	// The peer sent messages faster than its rate limits allow
+	ErrorRateLimited
+	// The peer is not allowed to do what it asked
+	ErrorUnauthorized
+	// The offline recipient has too many messages held already
+	ErrorQueueFull
+	// The server failed to handle a valid request
+	ErrorInternal
+	// The server refused the message
+	ErrorRejected
+	// The message is larger than the server accepts
+	ErrorTooLarge
+)
+
+func (c ErrorCode) MarshalJSON() ([]byte, error) {
+	if c < ErrorInvalidMessage || c > ErrorTooLarge {
+		return nil, fmt.Errorf("unknown ErrorCode %d", c)
+	}
+	return json.Marshal(c.String())
+}
+
+func (c *ErrorCode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	switch s {
+	case "invalid_message":
+		*c = ErrorInvalidMessage
+	case "unknown_kind":
+		*c = ErrorUnknownKind
+	case "unknown_reach":
+		*c = ErrorUnknownReach
+	case "unknown_peer":
+		*c = ErrorUnknownPeer
+	case "not_in_room":
+		*c = ErrorNotInRoom
+	case "already_in_room":
+		*c = ErrorAlreadyInRoom
+	case "rate_limited":
+		*c = ErrorRateLimited
+	case "unauthorized":
+		*c = ErrorUnauthorized
+	case "queue_full":
+		*c = ErrorQueueFull
+	case "internal":
+		*c = ErrorInternal
+	case "rejected":
+		*c = ErrorRejected
+	case "too_large":
+		*c = ErrorTooLarge
+	default:
+		return fmt.Errorf("unknown ErrorCode string %s", s)
+	}
+	return nil
+}
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorInvalidMessage:
+		return "invalid_message"
+	case ErrorUnknownKind:
+		return "unknown_kind"
+	case ErrorUnknownReach:
+		return "unknown_reach"
+	case ErrorUnknownPeer:
+		return "unknown_peer"
+	case ErrorNotInRoom:
+		return "not_in_room"
+	case ErrorAlreadyInRoom:
+		return "already_in_room"
+	case ErrorRateLimited:
+		return "rate_limited"
+	case ErrorUnauthorized:
+		return "unauthorized"
+	case ErrorQueueFull:
+		return "queue_full"
+	case ErrorInternal:
+		return "internal"
+	case ErrorRejected:
+		return "rejected"
+	case ErrorTooLarge:
+		return "too_large"
+	default:
+		return fmt.Sprintf("ErrorCode(%d)", int(c))
+	}
+}
@@ -0,0 +1,26 @@
+package message
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestErrorCodeJSON(t *testing.T) {
+	for code := ErrorInvalidMessage; code <= ErrorTooLarge; code++ {
+		data, err := json.Marshal(code)
+		if err != nil {
+			t.Fatalf("marshaling %v: %v", code, err)
+		}
+		var decoded ErrorCode
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != code {
+			t.Errorf("%s decoded as %v, %v", data, decoded, err)
+		}
+	}
+	if _, err := json.Marshal(ErrorTooLarge + 1); err == nil {
+		t.Error("marshaled an unknown code")
+	}
+	var code ErrorCode
+	if err := json.Unmarshal([]byte(`"bogus"`), &code); err == nil {
+		t.Error("unmarshaled an unknown code")
+	}
+}
@@ -7,10 +7,12 @@ import (
 )
 
 type Message struct {
+	ID      string          `json:"id,omitempty"` // set by the client to match acks and errors to its requests
 	Kind    MessageType     `json:"kind"`
 	Reach   ReachType       `json:"reach"`
 	Sender  string          `json:"sender"`
 	PeerID  string          `json:"peerID"`
+	Room    string          `json:"room,omitempty"` // target room, used with the Room reach type
 	Content json.RawMessage `json:"content"`
 }
 
@@ -48,7 +50,52 @@ func (m *Message) UnmarshalContent() (interface{}, error) {
 		var disconnectedContent DisconnectionNotificationContent
 		err := json.Unmarshal(m.Content, &disconnectedContent)
 		return disconnectedContent, err
+	case JoinRoom:
+		var joinRoom JoinRoomContent
+		err := json.Unmarshal(m.Content, &joinRoom)
+		return joinRoom, err
+	case LeaveRoom:
+		var leaveRoom LeaveRoomContent
+		err := json.Unmarshal(m.Content, &leaveRoom)
+		return leaveRoom, err
+	case PeerJoined:
+		var peerJoined PeerJoinedContent
+		err := json.Unmarshal(m.Content, &peerJoined)
+		return peerJoined, err
+	case SubscribePeers, PeersChanged:
+		var peerList PeerListContent
+		err := json.Unmarshal(m.Content, &peerList)
+		return peerList, err
+	case UnsubscribePeers:
+		return nil, nil
+	case SetMetadata:
+		var setMetadata SetMetadataContent
+		err := json.Unmarshal(m.Content, &setMetadata)
+		return setMetadata, err
+	case GetPeers:
+		var getPeers GetPeersContent
+		err := json.Unmarshal(m.Content, &getPeers)
+		return getPeers, err
+	case Error:
+		var errorContent ErrorContent
+		err := json.Unmarshal(m.Content, &errorContent)
+		return errorContent, err
+	case Ack:
+		var ack AckContent
+		err := json.Unmarshal(m.Content, &ack)
+		return ack, err
+	case Hello, Welcome:
+		var handshake HandshakeContent
+		err := json.Unmarshal(m.Content, &handshake)
+		return handshake, err
+	case ServerShutdown:
+		var shutdown ServerShutdownContent
+		err := json.Unmarshal(m.Content, &shutdown)
+		return shutdown, err
 	default:
+		if content, ok, err := unmarshalCustomContent(m.Kind, m.Content); ok {
+			return content, err
+		}
 		log.Printf("Invalid message kind %d\n", m.Kind)
 		return nil, fmt.Errorf("invalid message kind %d", m.Kind)
 	}
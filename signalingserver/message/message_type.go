@@ -3,6 +3,7 @@ package message
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type MessageType int
@@ -14,8 +15,21 @@ const (
 	Offer        // webrtc specific
 	Answer       // webrtc specific
 	ICECandidate // webrtc specific
-	IdentifySelf 
-	DisconnectionNotification 
+	IdentifySelf
+	DisconnectionNotification
+	JoinRoom
+	LeaveRoom
+	PeerJoined
+	SubscribePeers
+	UnsubscribePeers
+	PeersChanged
+	SetMetadata
+	GetPeers
+	Error
+	Ack
+	Hello
+	Welcome
+	ServerShutdown
 	End
 )
 
@@ -37,7 +51,36 @@ func (m MessageType) MarshalJSON() ([]byte, error) {
 		return json.Marshal("IdentifySelf")
 	case DisconnectionNotification:
 		return json.Marshal("DisconnectionNotification")
+	case JoinRoom:
+		return json.Marshal("JoinRoom")
+	case LeaveRoom:
+		return json.Marshal("LeaveRoom")
+	case PeerJoined:
+		return json.Marshal("PeerJoined")
+	case SubscribePeers:
+		return json.Marshal("SubscribePeers")
+	case UnsubscribePeers:
+		return json.Marshal("UnsubscribePeers")
+	case PeersChanged:
+		return json.Marshal("PeersChanged")
+	case SetMetadata:
+		return json.Marshal("SetMetadata")
+	case GetPeers:
+		return json.Marshal("GetPeers")
+	case Error:
+		return json.Marshal("Error")
+	case Ack:
+		return json.Marshal("Ack")
+	case Hello:
+		return json.Marshal("Hello")
+	case Welcome:
+		return json.Marshal("Welcome")
+	case ServerShutdown:
+		return json.Marshal("ServerShutdown")
 	default:
+		if name, ok := customKindName(m); ok {
+			return json.Marshal(name)
+		}
 		return nil, fmt.Errorf("unknown MessageType: %d", m)
 	}
 }
@@ -64,9 +107,39 @@ func (m *MessageType) UnmarshalJSON(data []byte) error {
 		*m = IdentifySelf
 	case "DisconnectionNotification":
 		*m = DisconnectionNotification
+	case "JoinRoom":
+		*m = JoinRoom
+	case "LeaveRoom":
+		*m = LeaveRoom
+	case "PeerJoined":
+		*m = PeerJoined
+	case "SubscribePeers":
+		*m = SubscribePeers
+	case "UnsubscribePeers":
+		*m = UnsubscribePeers
+	case "PeersChanged":
+		*m = PeersChanged
+	case "SetMetadata":
+		*m = SetMetadata
+	case "GetPeers":
+		*m = GetPeers
+	case "Error":
+		*m = Error
+	case "Ack":
+		*m = Ack
+	case "Hello":
+		*m = Hello
+	case "Welcome":
+		*m = Welcome
+	case "ServerShutdown":
+		*m = ServerShutdown
 
 	default:
-		return fmt.Errorf("unknown MessageType string: %s", s)
+		kind, ok := customKindByName(s)
+		if !ok {
+			return fmt.Errorf("unknown MessageType string: %s", s)
+		}
+		*m = kind
 	}
 	return nil
 }
@@ -75,7 +148,7 @@ type GetAllPeerIDsContent struct {
 	PeersIDs []string `json:"peersIDs"`
 }
 type TextMessageContent struct {
-	Title string `json:"title"`
+	Title   string `json:"title"`
 	Message string `json:"message"`
 }
 type DisconnectContent struct {
@@ -90,15 +163,103 @@ type AnswerContent struct {
 	SDP  string `json:"sdp"`
 }
 type ICECandidateContent struct {
-	Candidate     string `json:"candidate"`
-	SdpMid        *string `json:"sdpMid"`
-	SdpMLineIndex *uint16 `json:"sdpMLineIndex"`
+	Candidate        string  `json:"candidate"`
+	SdpMid           *string `json:"sdpMid"`
+	SdpMLineIndex    *uint16 `json:"sdpMLineIndex"`
 	UsernameFragment *string `json:"usernameFragment"`
 }
 type IdentifySelfContent struct {
 	ID string `json:"id"`
-};
+	// Token to reconnect with to keep the same ID, set when the server supports session resumption
+	ResumeToken string `json:"resumeToken,omitempty"`
+	// Metadata the peer attaches to its ID when sent with IdentifySelf, echoed back by the server
+	Metadata *PeerMetadata `json:"metadata,omitempty"`
+}
+
+type DisconnectionNotificationContent struct {
+	DisconnectedPeerID string           `json:"disconnectedPeerID"`
+	Reason             DisconnectReason `json:"reason"`
+}
+
+// The server echoes JoinRoom/LeaveRoom back to the requesting peer and
+// notifies the other room members, with PeerID set to the joining/leaving peer.
+type JoinRoomContent struct {
+	Room   string `json:"room"`
+	PeerID string `json:"peerID,omitempty"`
+}
+type LeaveRoomContent struct {
+	Room   string `json:"room"`
+	PeerID string `json:"peerID,omitempty"`
+}
+
+type PeerJoinedContent struct {
+	PeerID string `json:"peerID"`
+}
+
+// PeerListContent is the snapshot answering SubscribePeers, which lists PeersIDs,
+// and the PeersChanged updates that follow it, which list Joined and Left.
+// Version starts at 1 with the snapshot and grows by one with every update.
+type PeerListContent struct {
+	Version  uint64   `json:"version"`
+	PeersIDs []string `json:"peersIDs,omitempty"`
+	Joined   []string `json:"joined,omitempty"`
+	Left     []string `json:"left,omitempty"`
+}
+
+// PeerMetadata describes a peer to the others. App holds arbitrary application values.
+type PeerMetadata struct {
+	DisplayName string            `json:"displayName,omitempty"`
+	Role        string            `json:"role,omitempty"`
+	DeviceType  string            `json:"deviceType,omitempty"`
+	Codecs      []string          `json:"codecs,omitempty"`
+	App         map[string]string `json:"app,omitempty"`
+}
 
-type DisconnectionNotificationContent struct{
-	DisconnectedPeerID string `json:"disconnectedPeerID"`
-}
\ No newline at end of file
+// The server echoes SetMetadata back to the requesting peer and notifies the peers
+// that can see it, with PeerID set to the updated peer.
+type SetMetadataContent struct {
+	PeerID   string       `json:"peerID,omitempty"`
+	Metadata PeerMetadata `json:"metadata"`
+}
+
+// GetPeersContent is sent with Filter to list the visible peers whose metadata has
+// every given field, and returned with Peers. Filter keys are "displayName", "role",
+// "deviceType", "codec" (one of the peer's codecs) and "app.<key>".
+type GetPeersContent struct {
+	Filter map[string]string `json:"filter,omitempty"`
+	Peers  []PeerInfo        `json:"peers"`
+}
+
+type PeerInfo struct {
+	ID       string       `json:"id"`
+	Metadata PeerMetadata `json:"metadata"`
+	JoinedAt time.Time    `json:"joinedAt"`
+}
+
+// ErrorContent is what the server answers a request it could not handle with.
+// RequestID is the ID of the offending message, if the client set one.
+type ErrorContent struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"requestID,omitempty"`
+	// How long to wait before sending again, set with ErrorRateLimited
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+// ServerShutdownContent tells peers the server is going away and their connection is
+// about to close. ReconnectAfterMs, when set, is how long the client should wait before
+// reconnecting.
+type ServerShutdownContent struct {
+	Message          string `json:"message,omitempty"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs,omitempty"`
+}
+
+// String returns the name of the kind on the wire.
+func (m MessageType) String() string {
+	data, err := m.MarshalJSON()
+	var name string
+	if err != nil || json.Unmarshal(data, &name) != nil {
+		return fmt.Sprintf("MessageType(%d)", int(m))
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+package message
+
+// ProtocolVersion is the version of the wire protocol defined by this package.
+//
+// Version 1 is the protocol of clients that do not send Hello: the message kinds from
+// GetAllPeerIDs to DisconnectionNotification, and the reach types from Self to None.
+// Version 2 adds the Hello/Welcome handshake, in which client and server agree on
+// the features below. The server only sends a client the kinds of the features they
+// agreed on, or that the client used itself.
+const ProtocolVersion = 2
+
+// Features a client and the server can agree on in the handshake
+const (
+	// JoinRoom, LeaveRoom and the Room reach type
+	FeatureRooms = "rooms"
+	// PeerJoined, SubscribePeers, UnsubscribePeers and PeersChanged
+	FeaturePresence = "presence"
+	// SetMetadata and GetPeers
+	FeatureMetadata = "metadata"
+	// Error messages, older clients get a TextMessage titled "error" instead
+	FeatureErrors = "errors"
+	// Ack of OnePeer messages that have an ID
+	FeatureAcks = "acks"
+	// Session resumption with the token from IdentifySelf
+	FeatureResume = "resume"
+	// Messages to offline peers are held until they connect again
+	FeatureStoreAndForward = "store_and_forward"
+	// Messages over the rate limits are rejected
+	FeatureRateLimits = "rate_limits"
+	// ServerShutdown before the server closes connections to go away
+	FeatureShutdown = "shutdown"
+)
+
+// Each application-defined kind is a feature of its own: FeatureKindPrefix followed by its name.
+
+// HandshakeContent is the content of Hello, in which the client sends the highest version
+// it speaks and the features it wants (all the server has when empty), and of Welcome,
+// in which the server answers with the version and features to use.
+type HandshakeContent struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features,omitempty"`
+	// ID of the peer, set in Welcome
+	PeerID string `json:"peerID,omitempty"`
+}
+
+// Feature returns the feature kind belongs to, or "" for the kinds every client knows.
+func (m MessageType) Feature() string {
+	switch m {
+	case JoinRoom, LeaveRoom:
+		return FeatureRooms
+	case PeerJoined, SubscribePeers, UnsubscribePeers, PeersChanged:
+		return FeaturePresence
+	case SetMetadata, GetPeers:
+		return FeatureMetadata
+	case Error:
+		return FeatureErrors
+	case Ack:
+		return FeatureAcks
+	case ServerShutdown:
+		return FeatureShutdown
+	default:
+		if name, ok := customKindName(m); ok {
+			return FeatureKindPrefix + name
+		}
+		return ""
+	}
+}
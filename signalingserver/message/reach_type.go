@@ -12,6 +12,7 @@ const (
 	OnePeer
 	AllPeers
 	None
+	Room // all members of Message.Room
 )
 
 func (r ReachType) MarshalJSON() ([]byte, error) {
@@ -24,6 +25,8 @@ func (r ReachType) MarshalJSON() ([]byte, error) {
 		return json.Marshal("AllPeers")
 	case None:
 		return json.Marshal("None")
+	case Room:
+		return json.Marshal("Room")
 	default:
 		return nil, fmt.Errorf("unknown ReachType %d", r)
 	}
@@ -42,8 +45,20 @@ func (r *ReachType) UnmarshalJSON(data []byte) error {
 		*r = AllPeers
 	case "None":
 		*r = None
+	case "Room":
+		*r = Room
 	default:
 		return fmt.Errorf("unknown ReachType string %s", s)
 	}
 	return nil
 }
+
+// String returns the name of the reach type on the wire.
+func (r ReachType) String() string {
+	data, err := r.MarshalJSON()
+	var name string
+	if err != nil || json.Unmarshal(data, &name) != nil {
+		return fmt.Sprintf("ReachType(%d)", int(r))
+	}
+	return name
+}
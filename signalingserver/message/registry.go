@@ -0,0 +1,115 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// Application-defined kinds are numbered from customKindBase, clear of the built-in ones.
+const customKindBase MessageType = 1000
+
+// Prefix of the feature of each application-defined kind, followed by its name
+const FeatureKindPrefix = "kind:"
+
+type customKind struct {
+	name        string
+	contentType reflect.Type
+}
+
+var customKinds = struct {
+	sync.RWMutex
+	byKind map[MessageType]customKind
+	byName map[string]MessageType
+}{
+	byKind: make(map[MessageType]customKind),
+	byName: make(map[string]MessageType),
+}
+
+// RegisterKind registers an application-defined message kind under name, its string on
+// the wire. content is a value of the kind's content type, which UnmarshalContent decodes
+// into, or nil to leave the content as raw JSON. Clients and server must register the
+// same kinds; the returned MessageType is only meaningful within this process.
+func RegisterKind(name string, content any) (MessageType, error) {
+	if name == "" {
+		return 0, fmt.Errorf("kind name must not be empty")
+	}
+	if _, exist := customKindByName(name); exist {
+		return 0, fmt.Errorf("kind %s is already registered", name)
+	}
+	// name is not a custom kind, so it parses only if it is a built-in one
+	var builtin MessageType
+	if builtin.UnmarshalJSON([]byte(fmt.Sprintf("%q", name))) == nil {
+		return 0, fmt.Errorf("kind %s is built in", name)
+	}
+	customKinds.Lock()
+	defer customKinds.Unlock()
+	if _, exist := customKinds.byName[name]; exist {
+		return 0, fmt.Errorf("kind %s is already registered", name)
+	}
+	kind := customKindBase + MessageType(len(customKinds.byKind))
+	customKinds.byKind[kind] = customKind{name: name, contentType: reflect.TypeOf(content)}
+	customKinds.byName[name] = kind
+	return kind, nil
+}
+
+// MustRegisterKind is like RegisterKind but panics if the kind cannot be registered.
+func MustRegisterKind(name string, content any) MessageType {
+	kind, err := RegisterKind(name, content)
+	if err != nil {
+		panic(err)
+	}
+	return kind
+}
+
+// IsCustom reports whether m is an application-defined kind.
+func (m MessageType) IsCustom() bool {
+	customKinds.RLock()
+	defer customKinds.RUnlock()
+	_, exist := customKinds.byKind[m]
+	return exist
+}
+
+// CustomKinds returns the application-defined kinds in the order they were registered.
+func CustomKinds() []MessageType {
+	customKinds.RLock()
+	defer customKinds.RUnlock()
+	kinds := make([]MessageType, 0, len(customKinds.byKind))
+	for kind := range customKinds.byKind {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+func customKindName(m MessageType) (string, bool) {
+	customKinds.RLock()
+	defer customKinds.RUnlock()
+	custom, exist := customKinds.byKind[m]
+	return custom.name, exist
+}
+
+func customKindByName(name string) (MessageType, bool) {
+	customKinds.RLock()
+	defer customKinds.RUnlock()
+	kind, exist := customKinds.byName[name]
+	return kind, exist
+}
+
+// unmarshalCustomContent decodes content into a new value of the content type of kind.
+func unmarshalCustomContent(kind MessageType, content json.RawMessage) (any, bool, error) {
+	customKinds.RLock()
+	custom, exist := customKinds.byKind[kind]
+	customKinds.RUnlock()
+	if !exist {
+		return nil, false, nil
+	}
+	if custom.contentType == nil {
+		return content, true, nil
+	}
+	value := reflect.New(custom.contentType)
+	err := json.Unmarshal(content, value.Interface())
+	return value.Elem().Interface(), true, err
+}
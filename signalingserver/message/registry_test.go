@@ -0,0 +1,59 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type muteContent struct {
+	Muted bool `json:"muted"`
+}
+
+var (
+	muteKind = MustRegisterKind("test.mute", muteContent{})
+	rpcKind  = MustRegisterKind("test.rpc", nil)
+)
+
+func TestRegisterKind(t *testing.T) {
+	for _, name := range []string{"", "Offer", "test.mute"} {
+		if _, err := RegisterKind(name, nil); err == nil {
+			t.Errorf("registered kind %q", name)
+		}
+	}
+	if !muteKind.IsCustom() || Offer.IsCustom() {
+		t.Error("IsCustom does not tell registered kinds from built-in ones")
+	}
+	if fmt.Sprint(CustomKinds()) != fmt.Sprint([]MessageType{muteKind, rpcKind}) {
+		t.Errorf("custom kinds are %v, want mute and rpc in order", CustomKinds())
+	}
+	if feature := rpcKind.Feature(); feature != FeatureKindPrefix+"test.rpc" {
+		t.Errorf("feature of rpc is %s", feature)
+	}
+}
+
+func TestCustomKindMessages(t *testing.T) {
+	data, err := json.Marshal(Message{Kind: muteKind, Reach: AllPeers, Content: json.RawMessage(`{"muted":true}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Kind != muteKind {
+		t.Fatalf("%s decoded as kind %v, %v", data, msg.Kind, err)
+	}
+	content, err := msg.UnmarshalContent()
+	if mute, ok := content.(muteContent); err != nil || !ok || !mute.Muted {
+		t.Errorf("content decoded as %#v, %v, want muteContent", content, err)
+	}
+
+	msg = Message{Kind: rpcKind, Content: json.RawMessage(`{"method":"ping"}`)}
+	content, err = msg.UnmarshalContent()
+	if raw, ok := content.(json.RawMessage); err != nil || !ok || string(raw) != `{"method":"ping"}` {
+		t.Errorf("content without a type decoded as %#v, %v, want the raw JSON", content, err)
+	}
+
+	var kind MessageType
+	if err := kind.UnmarshalJSON([]byte(`"test.unregistered"`)); err == nil {
+		t.Errorf("unregistered kind decoded as %v", kind)
+	}
+}
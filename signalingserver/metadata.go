@@ -0,0 +1,119 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// Maximum size of the JSON encoded metadata of a peer
+const maxMetadataSize = 4096
+
+// Prefix of GetPeers filter keys that match application values of the metadata
+const appFilterPrefix = "app."
+
+// getMetadata returns the current metadata of p.
+func (p *peer) getMetadata() message.PeerMetadata {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.metadata
+}
+
+func (p *peer) setMetadata(metadata message.PeerMetadata) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metadata = metadata
+}
+
+func (p *peer) info() message.PeerInfo {
+	return message.PeerInfo{ID: p.id, Metadata: p.getMetadata(), JoinedAt: p.joinedAt}
+}
+
+func validMetadata(metadata message.PeerMetadata) bool {
+	data, err := json.Marshal(metadata)
+	return err == nil && len(data) <= maxMetadataSize
+}
+
+// updateMetadata replaces the metadata of p and tells the peers that can see it.
+func (s *SignalingServer) updateMetadata(p *peer, metadata message.PeerMetadata) {
+	p.setMetadata(metadata)
+	content, err := json.Marshal(message.SetMetadataContent{PeerID: p.id, Metadata: metadata})
+	if err != nil {
+		p.logger.Error("Failed to marshal metadata", "error", err)
+		return
+	}
+	notification := message.Message{Kind: message.SetMetadata, Reach: message.AllPeers, Sender: "server", PeerID: p.id, Content: content}
+	for _, other := range s.visiblePeers(p) {
+		if other == p {
+			continue
+		}
+		if err := s.send(other, notification); err != nil {
+			other.logger.Warn("Failed to send metadata update", "error", err)
+		}
+	}
+}
+
+// discoveryScope returns the peers p can list: the members of room, or the peers visible
+// to p when room is empty. It reports false if p is not a member of room.
+func (s *SignalingServer) discoveryScope(p *peer, room string) ([]*peer, bool) {
+	if room == "" {
+		return s.visiblePeers(p), true
+	}
+	if !s.rooms.isMember(room, p) {
+		return nil, false
+	}
+	return s.rooms.members(room), true
+}
+
+// peerInfos returns the ID, metadata and join time of the peers of scope matching filter,
+// oldest first, leaving out self unless the server is configured to include it.
+func (s *SignalingServer) peerInfos(scope []*peer, self *peer, filter map[string]string) []message.PeerInfo {
+	infos := make([]message.PeerInfo, 0, len(scope))
+	for _, p := range scope {
+		if p == self && !s.addSelfToGetPeerIDs {
+			continue
+		}
+		info := p.info()
+		if matchesFilter(info.Metadata, filter) {
+			infos = append(infos, info)
+		}
+	}
+	slices.SortFunc(infos, func(a, b message.PeerInfo) int {
+		return a.JoinedAt.Compare(b.JoinedAt)
+	})
+	return infos
+}
+
+func validFilterField(field string) bool {
+	switch field {
+	case "displayName", "role", "deviceType", "codec":
+		return true
+	}
+	return strings.HasPrefix(field, appFilterPrefix) && len(field) > len(appFilterPrefix)
+}
+
+// matchesFilter reports whether metadata has every value of filter.
+func matchesFilter(metadata message.PeerMetadata, filter map[string]string) bool {
+	for field, value := range filter {
+		var matches bool
+		switch field {
+		case "displayName":
+			matches = metadata.DisplayName == value
+		case "role":
+			matches = metadata.Role == value
+		case "deviceType":
+			matches = metadata.DeviceType == value
+		case "codec":
+			matches = slices.Contains(metadata.Codecs, value)
+		default:
+			appValue, exist := metadata.App[strings.TrimPrefix(field, appFilterPrefix)]
+			matches = exist && appValue == value
+		}
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
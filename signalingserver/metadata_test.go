@@ -0,0 +1,95 @@
+package signalingserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+func TestMatchesFilter(t *testing.T) {
+	metadata := message.PeerMetadata{DisplayName: "Ann", Role: "host", DeviceType: "phone", Codecs: []string{"vp8", "opus"}, App: map[string]string{"team": "x"}}
+	tests := []struct {
+		filter  map[string]string
+		matches bool
+	}{
+		{nil, true},
+		{map[string]string{"displayName": "Ann"}, true},
+		{map[string]string{"displayName": "ann"}, false},
+		{map[string]string{"role": "host", "deviceType": "phone"}, true},
+		{map[string]string{"role": "host", "deviceType": "desktop"}, false},
+		{map[string]string{"codec": "opus"}, true},
+		{map[string]string{"codec": "h264"}, false},
+		{map[string]string{"app.team": "x"}, true},
+		{map[string]string{"app.team": "y"}, false},
+		{map[string]string{"app.floor": ""}, false},
+	}
+	for _, test := range tests {
+		if matches := matchesFilter(metadata, test.filter); matches != test.matches {
+			t.Errorf("filter %v matches %v, want %v", test.filter, matches, test.matches)
+		}
+	}
+}
+
+// getPeers lists the peers visible to c whose metadata matches filter.
+func (c *testClient) getPeers(filter map[string]string) []message.PeerInfo {
+	c.t.Helper()
+	c.sendContent(message.GetPeers, message.Self, message.GetPeersContent{Filter: filter})
+	var content message.GetPeersContent
+	unmarshalContent(c.t, c.receiveKind(message.GetPeers), &content)
+	return content.Peers
+}
+
+func TestPeerMetadata(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	a := dialFeatures(t, url, message.FeatureMetadata, message.FeatureErrors)
+	b := dialFeatures(t, url, message.FeatureMetadata, message.FeatureErrors)
+	idA, idB := a.identify(), b.identify()
+
+	// through IdentifySelf
+	ann := message.PeerMetadata{DisplayName: "Ann", Role: "host", Codecs: []string{"vp8"}}
+	a.sendContent(message.IdentifySelf, message.Self, message.IdentifySelfContent{Metadata: &ann})
+	var identified message.IdentifySelfContent
+	unmarshalContent(t, a.receiveKind(message.IdentifySelf), &identified)
+	if identified.ID != idA || identified.Metadata == nil || identified.Metadata.DisplayName != "Ann" {
+		t.Errorf("a got %+v, want its ID and metadata", identified)
+	}
+	var update message.SetMetadataContent
+	unmarshalContent(t, b.receiveKind(message.SetMetadata), &update)
+	if update.PeerID != idA || update.Metadata.Role != "host" {
+		t.Errorf("b was told %+v, want the metadata of a", update)
+	}
+
+	// through SetMetadata
+	bob := message.PeerMetadata{DisplayName: "Bob", Role: "guest", App: map[string]string{"team": "x"}}
+	b.sendContent(message.SetMetadata, message.Self, message.SetMetadataContent{Metadata: bob})
+	unmarshalContent(t, b.receiveKind(message.SetMetadata), &update)
+	if update.PeerID != idB || update.Metadata.DisplayName != "Bob" {
+		t.Errorf("b got %+v, want its own metadata echoed", update)
+	}
+	unmarshalContent(t, a.receiveKind(message.SetMetadata), &update)
+	if update.PeerID != idB || update.Metadata.App["team"] != "x" {
+		t.Errorf("a was told %+v, want the metadata of b", update)
+	}
+
+	if peers := a.getPeers(map[string]string{"app.team": "x"}); len(peers) != 1 || peers[0].ID != idB || peers[0].Metadata.DisplayName != "Bob" || peers[0].JoinedAt.IsZero() {
+		t.Errorf("a found %+v, want b with its metadata and join time", peers)
+	}
+	if peers := b.getPeers(map[string]string{"codec": "h264"}); len(peers) != 0 {
+		t.Errorf("b found %+v, want none", peers)
+	}
+	if peers := b.getPeers(nil); len(peers) != 1 || peers[0].ID != idA {
+		t.Errorf("b found %+v, want a", peers)
+	}
+
+	b.sendContent(message.GetPeers, message.Self, message.GetPeersContent{Filter: map[string]string{"bogus": "x"}})
+	if content := b.receiveError(); content.Code != message.ErrorInvalidMessage {
+		t.Errorf("unknown filter field: got error %v, want %v", content.Code, message.ErrorInvalidMessage)
+	}
+	large := message.PeerMetadata{DisplayName: strings.Repeat("x", maxMetadataSize)}
+	b.sendContent(message.SetMetadata, message.Self, message.SetMetadataContent{Metadata: large})
+	if content := b.receiveError(); content.Code != message.ErrorInvalidMessage {
+		t.Errorf("oversized metadata: got error %v, want %v", content.Code, message.ErrorInvalidMessage)
+	}
+}
@@ -0,0 +1,163 @@
+package signalingserver
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// Upper bounds of the buckets of the fan-out histogram, in recipients
+var fanOutBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// Upper bounds of the buckets of the relay latency histogram, in seconds
+var relayLatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25}
+
+// metrics are the counters and histograms the server exposes to Prometheus.
+type metrics struct {
+	unknownPeers      atomic.Uint64
+	unmarshalFailures atomic.Uint64
+
+	mu           sync.Mutex
+	received     map[routeLabels]uint64
+	relayed      map[routeLabels]uint64
+	fanOut       histogram
+	relayLatency histogram
+}
+
+// routeLabels are the labels of the per kind and reach counters.
+type routeLabels struct {
+	kind  message.MessageType
+	reach message.ReachType
+}
+
+type histogram struct {
+	buckets []float64
+	// counts[i] counts the observations in bucket i alone, the last one those above every bound
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) histogram {
+	return histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	i, _ := slices.BinarySearch(h.buckets, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		received:     make(map[routeLabels]uint64),
+		relayed:      make(map[routeLabels]uint64),
+		fanOut:       newHistogram(fanOutBuckets),
+		relayLatency: newHistogram(relayLatencyBuckets),
+	}
+}
+
+func (m *metrics) messageReceived(msg message.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received[routeLabels{msg.Kind, msg.Reach}]++
+}
+
+func (m *metrics) messageRelayed(msg message.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.relayed[routeLabels{msg.Kind, msg.Reach}]++
+}
+
+// broadcastSent records how many peers a broadcast reached.
+func (m *metrics) broadcastSent(recipients int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fanOut.observe(float64(recipients))
+}
+
+// relayDone records how long the server took to route a message read at start.
+func (m *metrics) relayDone(start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.relayLatency.observe(time.Since(start).Seconds())
+}
+
+// MetricsHandler returns an http.Handler that serves the metrics of the server in the
+// Prometheus text exposition format.
+func (s *SignalingServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		out := bufio.NewWriter(w)
+		s.writeMetrics(out)
+		if err := out.Flush(); err != nil {
+			s.logger.Warn("Failed to write metrics", "error", err)
+		}
+	})
+}
+
+func (s *SignalingServer) writeMetrics(w *bufio.Writer) {
+	writeHeader(w, "signalingserver_connected_peers", "gauge", "Peers currently registered, including those that may still resume their session.")
+	fmt.Fprintf(w, "signalingserver_connected_peers %d\n", s.peers.len())
+
+	writeHeader(w, "signalingserver_unknown_peer_total", "counter", "OnePeer messages addressed to a peer ID that does not exist.")
+	fmt.Fprintf(w, "signalingserver_unknown_peer_total %d\n", s.metrics.unknownPeers.Load())
+
+	writeHeader(w, "signalingserver_unmarshal_failures_total", "counter", "Messages that could not be decoded.")
+	fmt.Fprintf(w, "signalingserver_unmarshal_failures_total %d\n", s.metrics.unmarshalFailures.Load())
+
+	writeHeader(w, "signalingserver_write_errors_total", "counter", "Messages that could not be encoded or written to a peer.")
+	fmt.Fprintf(w, "signalingserver_write_errors_total %d\n", s.messageTotals.writeErrors.Load())
+
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	writeHeader(w, "signalingserver_messages_received_total", "counter", "Messages received from peers by kind and reach.")
+	writeRouteCounters(w, "signalingserver_messages_received_total", s.metrics.received)
+	writeHeader(w, "signalingserver_messages_relayed_total", "counter", "Messages relayed to a recipient by kind and reach.")
+	writeRouteCounters(w, "signalingserver_messages_relayed_total", s.metrics.relayed)
+	writeHeader(w, "signalingserver_broadcast_fanout", "histogram", "Recipients of AllPeers and Room messages.")
+	writeHistogram(w, "signalingserver_broadcast_fanout", &s.metrics.fanOut)
+	writeHeader(w, "signalingserver_relay_latency_seconds", "histogram", "Time from reading a OnePeer, AllPeers or Room message to routing it.")
+	writeHistogram(w, "signalingserver_relay_latency_seconds", &s.metrics.relayLatency)
+}
+
+func writeHeader(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeRouteCounters(w *bufio.Writer, name string, counters map[routeLabels]uint64) {
+	labels := make([]routeLabels, 0, len(counters))
+	for l := range counters {
+		labels = append(labels, l)
+	}
+	slices.SortFunc(labels, func(a, b routeLabels) int {
+		return cmp.Or(cmp.Compare(a.kind, b.kind), cmp.Compare(a.reach, b.reach))
+	})
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{kind=\"%s\",reach=\"%s\"} %d\n", name, labelEscaper.Replace(l.kind.String()), labelEscaper.Replace(l.reach.String()), counters[l])
+	}
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeHistogram(w *bufio.Writer, name string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
@@ -0,0 +1,77 @@
+package signalingserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 5, 10})
+	for _, value := range []float64{0, 1, 3, 10, 50} {
+		h.observe(value)
+	}
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	writeHistogram(w, "test", &h)
+	w.Flush()
+	want := `test_bucket{le="1"} 2
+test_bucket{le="5"} 3
+test_bucket{le="10"} 4
+test_bucket{le="+Inf"} 5
+test_sum 64
+test_count 5
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	a, b := dial(t, url), dial(t, url)
+	a.identify()
+	b.identify()
+
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "hi"})
+	b.receiveKind(message.TextMessage)
+	a.send(message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: "nobody", Content: json.RawMessage(`{}`)})
+	a.receiveError()
+	if err := a.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	a.receiveError()
+
+	w := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("content type is %s", contentType)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE signalingserver_connected_peers gauge\nsignalingserver_connected_peers 2\n",
+		"\nsignalingserver_unknown_peer_total 1\n",
+		"\nsignalingserver_unmarshal_failures_total 1\n",
+		"\nsignalingserver_write_errors_total 0\n",
+		"\n" + `signalingserver_messages_received_total{kind="IdentifySelf",reach="Self"} 2` + "\n",
+		"\n" + `signalingserver_messages_received_total{kind="TextMessage",reach="AllPeers"} 1` + "\n",
+		"\n" + `signalingserver_messages_received_total{kind="Offer",reach="OnePeer"} 1` + "\n",
+		"\n" + `signalingserver_messages_relayed_total{kind="TextMessage",reach="AllPeers"} 1` + "\n",
+		"\n" + `signalingserver_broadcast_fanout_bucket{le="0"} 0` + "\n" + `signalingserver_broadcast_fanout_bucket{le="1"} 1` + "\n",
+		"\nsignalingserver_relay_latency_seconds_count 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics miss %q", want)
+		}
+	}
+	if strings.Contains(body, `signalingserver_messages_relayed_total{kind="Offer"`) {
+		t.Error("the offer to an unknown peer is counted as relayed")
+	}
+}
@@ -0,0 +1,60 @@
+package signalingserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// LogInterceptor logs the connections, messages and disconnections of every peer to
+// logger, or to slog.Default() if it is nil. Messages are logged at debug level, without
+// their content.
+func LogInterceptor(logger *slog.Logger) Interceptor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return InterceptorFuncs{
+		Connect: func(p *Peer, r *http.Request) error {
+			logger.Info("Peer connected", "peer_id", p.ID(), "remote_addr", r.RemoteAddr)
+			return nil
+		},
+		Message: func(ctx context.Context, p *Peer, msg *message.Message) error {
+			logger.DebugContext(ctx, "Peer sent message", "peer_id", p.ID(), "kind", msg.Kind, "reach", msg.Reach,
+				"target", msg.PeerID, "room", msg.Room, "size", len(msg.Content))
+			return nil
+		},
+		Disconnect: func(p *Peer, reason message.DisconnectReason) {
+			logger.Info("Peer disconnected", "peer_id", p.ID(), "reason", reason)
+		},
+	}
+}
+
+// MaxContentSize rejects messages whose content is larger than size bytes.
+func MaxContentSize(size int) Interceptor {
+	return InterceptorFuncs{
+		Message: func(ctx context.Context, p *Peer, msg *message.Message) error {
+			if len(msg.Content) > size {
+				return Reject(message.ErrorTooLarge, fmt.Sprintf("Message content exceeds %d bytes", size))
+			}
+			return nil
+		},
+	}
+}
+
+// StampSender sets the sender of every message to what stamp returns for the peer that
+// sent it, or to its peer ID if stamp is nil, whether or not the server identifies
+// message senders. Answers from the server keep "server" as their sender.
+func StampSender(stamp func(p *Peer) string) Interceptor {
+	if stamp == nil {
+		stamp = (*Peer).ID
+	}
+	return InterceptorFuncs{
+		Message: func(ctx context.Context, p *Peer, msg *message.Message) error {
+			msg.Sender = stamp(p)
+			return nil
+		},
+	}
+}
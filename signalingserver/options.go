@@ -0,0 +1,76 @@
+package signalingserver
+
+import (
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// Option configures optional behavior of a SignalingServer.
+type Option func(*SignalingServer)
+
+// WithAuthenticator makes the server authenticate every request before upgrading it.
+// The verified identity is attached to the resulting peer.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(s *SignalingServer) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithSendQueueSize sets the capacity of each peer's outbound message queue.
+func WithSendQueueSize(size int) Option {
+	return func(s *SignalingServer) {
+		if size > 0 {
+			s.sendQueueSize = size
+		}
+	}
+}
+
+// WithOriginPolicy sets the origin policy of HandleWebSocketConn. The default is AllowSameOrigin,
+// which refuses pages served from other origins; servers used to accept every origin, which
+// AllowAllOrigins restores. Use HandlerWithOriginPolicy to mount handlers with other policies.
+func WithOriginPolicy(policy OriginPolicy) Option {
+	return func(s *SignalingServer) {
+		s.originPolicy = policy
+	}
+}
+
+// UpgraderConfig holds the websocket upgrader settings. Zero values keep gorilla's defaults.
+type UpgraderConfig struct {
+	HandshakeTimeout  time.Duration
+	ReadBufferSize    int
+	WriteBufferSize   int
+	Subprotocols      []string
+	EnableCompression bool
+}
+
+// WithUpgraderConfig configures the websocket upgrader used by every handler of the server.
+func WithUpgraderConfig(config UpgraderConfig) Option {
+	return func(s *SignalingServer) {
+		s.webSocketUpgrader.HandshakeTimeout = config.HandshakeTimeout
+		s.webSocketUpgrader.ReadBufferSize = config.ReadBufferSize
+		s.webSocketUpgrader.WriteBufferSize = config.WriteBufferSize
+		s.webSocketUpgrader.Subprotocols = config.Subprotocols
+		s.webSocketUpgrader.EnableCompression = config.EnableCompression
+	}
+}
+
+// WithCodecs sets the codecs clients can pick through the Sec-WebSocket-Protocol header,
+// most preferred first. The default is message.Codecs. Clients that offer none of them
+// use message.JSON.
+func WithCodecs(codecs ...message.Codec) Option {
+	return func(s *SignalingServer) {
+		s.codecs = codecs
+	}
+}
+
+// codecFor returns the codec of the negotiated subprotocol.
+func (s *SignalingServer) codecFor(subprotocol string) message.Codec {
+	for _, codec := range s.codecs {
+		if codec.Name() == subprotocol {
+			return codec
+		}
+	}
+	return message.JSON
+}
@@ -0,0 +1,142 @@
+package signalingserver
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OriginPolicy decides whether a websocket upgrade request may proceed based on its Origin header.
+// Requests rejected by the policy fail with 403 Forbidden before being upgraded.
+type OriginPolicy func(r *http.Request) bool
+
+// AllowAllOrigins accepts every origin. Only use it for servers that authenticate peers
+// by other means, as it leaves the server open to cross-site websocket hijacking.
+func AllowAllOrigins() OriginPolicy {
+	return func(r *http.Request) bool {
+		return true
+	}
+}
+
+// AllowSameOrigin accepts requests whose Origin matches the Host they were sent to,
+// and requests without an Origin header, which do not come from browsers.
+// It is the default policy.
+func AllowSameOrigin() OriginPolicy {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// AllowOrigins accepts the listed origins, e.g. "https://app.example.com".
+// A leading "*." in the host matches any subdomain, so "https://*.example.com" accepts
+// "https://meet.example.com" but not "https://example.com". An entry without a scheme
+// matches the host under any scheme.
+func AllowOrigins(origins ...string) OriginPolicy {
+	patterns := make([]originPattern, 0, len(origins))
+	for _, origin := range origins {
+		patterns = append(patterns, parseOriginPattern(origin))
+	}
+	return func(r *http.Request) bool {
+		scheme, host, ok := splitOrigin(r.Header.Get("Origin"))
+		if !ok {
+			return false
+		}
+		for _, pattern := range patterns {
+			if pattern.matches(scheme, host) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowOriginPatterns accepts origins matching any of the regular expressions.
+// Anchor the expressions, an unanchored one can match a prefix of an attacker's origin.
+func AllowOriginPatterns(patterns ...*regexp.Regexp) OriginPolicy {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return false
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AnyOriginPolicy accepts a request if any of the policies accepts it.
+func AnyOriginPolicy(policies ...OriginPolicy) OriginPolicy {
+	return func(r *http.Request) bool {
+		for _, policy := range policies {
+			if policy(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type originPattern struct {
+	scheme string // empty matches any scheme
+	host   string
+	// host is a suffix like ".example.com" matching any subdomain
+	wildcard bool
+}
+
+func parseOriginPattern(origin string) originPattern {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	var pattern originPattern
+	if scheme, host, found := strings.Cut(origin, "://"); found {
+		pattern.scheme, origin = scheme, host
+	}
+	if strings.HasPrefix(origin, "*.") {
+		pattern.wildcard = true
+		origin = origin[1:]
+	}
+	pattern.host = origin
+	return pattern
+}
+
+func (p originPattern) matches(scheme, host string) bool {
+	if p.scheme != "" && p.scheme != scheme {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(host, p.host)
+	}
+	return host == p.host
+}
+
+func splitOrigin(origin string) (scheme, host string, ok bool) {
+	if origin == "" {
+		return "", "", false
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", "", false
+	}
+	return u.Scheme, u.Host, true
+}
+
+// checkOrigin wraps policy into a websocket.Upgrader CheckOrigin func that logs rejections.
+func (s *SignalingServer) checkOrigin(policy OriginPolicy) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		if policy(r) {
+			return true
+		}
+		s.logger.Info("Rejected websocket origin", "origin", r.Header.Get("Origin"), "remote_addr", r.RemoteAddr)
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+package signalingserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func originRequest(origin string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://signal.example.com/ws", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+func TestOriginPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  OriginPolicy
+		allowed map[string]bool
+	}{
+		{"same origin", AllowSameOrigin(), map[string]bool{
+			"":                               true,
+			"http://signal.example.com":      true,
+			"https://SIGNAL.example.com":     true,
+			"http://evil.com":                false,
+			"http://signal.example.com.evil": false,
+			"http://signal.example.com:8080": false,
+			"%":                              false,
+		}},
+		{"origins", AllowOrigins("https://app.example.com", "https://*.example.org", "*.example.net"), map[string]bool{
+			"":                        false,
+			"https://app.example.com": true,
+			"https://APP.example.com": true,
+			"http://app.example.com":  false,
+			"https://example.com":     false,
+			"https://a.example.org":   true,
+			"https://a.b.example.org": true,
+			"https://example.org":     false,
+			"https://evilexample.org": false,
+			"http://x.example.net":    true,
+			"wss://x.example.net":     true,
+			"null":                    false,
+		}},
+		{"patterns", AllowOriginPatterns(regexp.MustCompile(`^https://meet-[0-9]+\.example\.com$`)), map[string]bool{
+			"":                             false,
+			"https://meet-42.example.com":  true,
+			"https://meet-x.example.com":   false,
+			"https://meet-42.example.com.": false,
+		}},
+		{"any", AnyOriginPolicy(AllowOrigins("https://a.example.com"), func(r *http.Request) bool {
+			return strings.HasSuffix(r.Header.Get("Origin"), ".test")
+		}), map[string]bool{
+			"https://a.example.com": true,
+			"http://local.test":     true,
+			"https://b.example.com": false,
+		}},
+		{"all", AllowAllOrigins(), map[string]bool{"": true, "http://evil.com": true}},
+	}
+	for _, test := range tests {
+		for origin, allowed := range test.allowed {
+			if got := test.policy(originRequest(origin)); got != allowed {
+				t.Errorf("%s: origin %q allowed %v, want %v", test.name, origin, got, allowed)
+			}
+		}
+	}
+}
+
+func TestHandlerOriginPolicies(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithOriginPolicy(AllowOrigins("https://app.example.com")),
+		WithUpgraderConfig(UpgraderConfig{Subprotocols: []string{"custom"}}))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.HandleWebSocketConn)
+	mux.Handle("/embed", s.HandlerWithOriginPolicy(AllowOrigins("https://partner.example.com")))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		path, origin string
+		allowed      bool
+	}{
+		{"/ws", "https://app.example.com", true},
+		{"/ws", "https://partner.example.com", false},
+		{"/ws", "", false},
+		{"/embed", "https://partner.example.com", true},
+		{"/embed", "https://app.example.com", false},
+	}
+	for _, test := range tests {
+		dialer := websocket.Dialer{Subprotocols: []string{"custom"}}
+		conn, resp, err := dialer.Dial(url+test.path, http.Header{"Origin": {test.origin}})
+		if !test.allowed {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: origin %q was accepted", test.path, test.origin)
+			} else if resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Errorf("%s: origin %q got %v, want 403", test.path, test.origin, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: origin %q was rejected: %v", test.path, test.origin, err)
+			continue
+		}
+		if conn.Subprotocol() != "custom" {
+			t.Errorf("%s: negotiated subprotocol %q, want custom", test.path, conn.Subprotocol())
+		}
+		conn.Close()
+	}
+}
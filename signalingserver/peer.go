@@ -0,0 +1,341 @@
+package signalingserver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// Default capacity of each peer's outbound message queue
+const defaultSendQueueSize = 256
+
+var (
+	errPeerClosed    = errors.New("peer connection is closed")
+	errSendQueueFull = errors.New("peer send queue is full")
+	errIdleTimeout   = errors.New("peer sent no message within the idle timeout")
+	errPongTimeout   = errors.New("peer did not answer pings in time")
+)
+
+// peer is a client registered with the server. With session resumption enabled it can
+// outlive its websocket connection: while it is detached, messages for it keep piling up
+// in its send queue until a new connection takes over.
+//
+// Gorilla allows only one concurrent writer per connection, so every write goes through
+// the send queue and is performed by the write pump of the current connection.
+type peer struct {
+	id string
+
+	// Verified identity of the peer, nil when the server has no authenticator
+	identity *auth.Identity
+
+	// Whether the client asked for the ID, which it gets again when it reconnects
+	preferredID bool
+
+	send chan message.Message
+
+	keepalive KeepaliveConfig
+
+	// Rate limits of the messages the peer sends, nil when rate limiting is disabled
+	limiter *rateLimiter
+
+	// protocol version and features the client understands
+	protocol atomic.Pointer[clientProtocol]
+
+	// when the peer registered
+	joinedAt time.Time
+
+	// logs with the peer ID attached
+	logger *slog.Logger
+
+	// messages read from and written to the peer, also added to the server wide totals
+	received atomic.Uint64
+	sent     atomic.Uint64
+	totals   *messageTotals
+
+	mu sync.Mutex
+	// what the peer told others about itself
+	metadata message.PeerMetadata
+	// client of the latest connection
+	remoteAddr string
+	userAgent  string
+	// messages received by kind
+	receivedKinds map[message.MessageType]uint64
+	// current connection, nil while the peer is detached
+	conn *peerConn
+	// message a failed write took off the queue, written first by the next connection
+	unsent *message.Message
+	// token the peer can present to resume its session, empty when resumption is disabled
+	resumeToken string
+	// expires the session of a detached peer
+	graceTimer *time.Timer
+	// set once the peer is gone for good, after which it cannot be resumed
+	left bool
+
+	// closed once the peer is gone for good
+	gone     chan struct{}
+	goneOnce sync.Once
+
+	// set when the server disconnects the peer
+	kicked atomic.Bool
+	// set when the peer asks to disconnect
+	quit atomic.Bool
+	// set once the other peers have been told about the disconnection
+	disconnectNotified atomic.Bool
+	// set once the interceptors have been told about the disconnection
+	intercepted atomic.Bool
+}
+
+// peerConn is one websocket connection of a peer.
+type peerConn struct {
+	ws *websocket.Conn
+
+	// encoding the client picked for the connection
+	codec message.Codec
+
+	// closed when the connection is being torn down
+	done      chan struct{}
+	closeOnce sync.Once
+	// close frame sent by the write pump once done is closed, nil for none
+	closeFrame []byte
+	// whether the write pump writes what is left in the queue before closing
+	flush bool
+
+	// closed when the write pump has returned
+	pumpDone chan struct{}
+}
+
+func newPeer(id string, sendQueueSize int, keepalive KeepaliveConfig) *peer {
+	p := &peer{
+		id:        id,
+		keepalive: keepalive,
+		joinedAt:  time.Now(),
+		logger:    slog.Default().With("peer_id", id),
+		send:      make(chan message.Message, sendQueueSize),
+		gone:      make(chan struct{}),
+	}
+	p.protocol.Store(legacyProtocol)
+	return p
+}
+
+func newPeerConn(ws *websocket.Conn, codec message.Codec) *peerConn {
+	return &peerConn{
+		ws:       ws,
+		codec:    codec,
+		done:     make(chan struct{}),
+		pumpDone: make(chan struct{}),
+	}
+}
+
+// enqueue queues msg for delivery without blocking the caller.
+func (p *peer) enqueue(msg message.Message) error {
+	select {
+	case <-p.gone:
+		return errPeerClosed
+	default:
+	}
+	select {
+	case p.send <- msg:
+		return nil
+	case <-p.gone:
+		return errPeerClosed
+	default:
+		return errSendQueueFull
+	}
+}
+
+// attach makes c the current connection of p and starts its write pump.
+func (p *peer) attach(c *peerConn) {
+	p.mu.Lock()
+	p.conn = c
+	p.mu.Unlock()
+	go p.writePump(c)
+}
+
+// current returns the connection p is attached to, nil while it is detached.
+func (p *peer) current() *peerConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn
+}
+
+// closeConn closes the current connection after sending a close frame with code and text,
+// and reports false if p has no connection.
+func (p *peer) closeConn(code int, text string) bool {
+	c := p.current()
+	if c == nil {
+		return false
+	}
+	c.closeWith(code, text)
+	return true
+}
+
+// leave marks p as gone for good and stops the write pump of its connection.
+// It is safe to call more than once.
+func (p *peer) leave() {
+	p.mu.Lock()
+	p.left = true
+	if p.graceTimer != nil {
+		p.graceTimer.Stop()
+	}
+	p.mu.Unlock()
+	p.goneOnce.Do(func() {
+		close(p.gone)
+	})
+}
+
+// markDisconnectNotified reports whether the caller is the first to handle notifying
+// other peers of this peer's disconnection.
+func (p *peer) markDisconnectNotified() bool {
+	return p.disconnectNotified.CompareAndSwap(false, true)
+}
+
+// closeWith stops the write pump after it writes the queued messages and a close frame
+// with code and text. Only the first call to abort or closeWith has an effect.
+func (c *peerConn) closeWith(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeFrame = websocket.FormatCloseMessage(code, text)
+		c.flush = true
+		close(c.done)
+	})
+}
+
+// abort stops the write pump of a connection that is already broken, leaving
+// queued messages for the next connection of the peer.
+func (c *peerConn) abort() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump is the only goroutine that writes to c. It also sends the keepalive pings.
+func (p *peer) writePump(c *peerConn) {
+	defer close(c.pumpDone)
+	defer c.ws.Close()
+	var pings <-chan time.Time
+	if p.keepalive.PingInterval > 0 {
+		ticker := time.NewTicker(p.keepalive.PingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	p.mu.Lock()
+	unsent := p.unsent
+	p.unsent = nil
+	p.mu.Unlock()
+	if unsent != nil && !p.write(c, *unsent) {
+		return
+	}
+	for {
+		select {
+		case msg := <-p.send:
+			if !p.write(c, msg) {
+				return
+			}
+		case <-pings:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(p.keepalive.WriteWait)); err != nil {
+				p.logger.Warn("Failed to ping peer", "error", err)
+				c.abort()
+				return
+			}
+		case <-c.done:
+			if c.flush {
+				p.flush(c)
+			}
+			p.writeCloseFrame(c)
+			return
+		case <-p.gone:
+			p.flush(c)
+			// the connection may have been closed with a frame right before p left
+			select {
+			case <-c.done:
+				p.writeCloseFrame(c)
+			default:
+			}
+			return
+		}
+	}
+}
+
+// writeCloseFrame sends the close frame c was closed with, if any.
+func (p *peer) writeCloseFrame(c *peerConn) {
+	if c.closeFrame != nil {
+		c.ws.WriteControl(websocket.CloseMessage, c.closeFrame, time.Now().Add(p.keepalive.WriteWait))
+	}
+}
+
+// flush writes whatever is still queued when the connection is closed.
+func (p *peer) flush(c *peerConn) {
+	for {
+		select {
+		case msg := <-p.send:
+			if !p.write(c, msg) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// write writes msg to c. When it fails, msg is kept for the next connection of p
+// and c is aborted.
+func (p *peer) write(c *peerConn, msg message.Message) bool {
+	data, err := c.codec.Encode(msg)
+	if err != nil {
+		// the message cannot be sent on any connection, so it is not kept
+		p.logger.Error("Failed to encode message", "kind", msg.Kind, "error", err)
+		p.writeFailed()
+		return true
+	}
+	frameType := websocket.TextMessage
+	if c.codec.Binary() {
+		frameType = websocket.BinaryMessage
+	}
+	c.ws.SetWriteDeadline(time.Now().Add(p.keepalive.WriteWait))
+	if err := c.ws.WriteMessage(frameType, data); err != nil {
+		p.logger.Warn("Failed to write message", "kind", msg.Kind, "error", err)
+		p.writeFailed()
+		p.mu.Lock()
+		p.unsent = &msg
+		p.mu.Unlock()
+		c.abort()
+		return false
+	}
+	p.sent.Add(1)
+	if p.totals != nil {
+		p.totals.sent.Add(1)
+	}
+	return true
+}
+
+// readLoop reads messages from c and passes them to handle until the connection
+// fails or the read deadline set by the keepalive config expires.
+func (p *peer) readLoop(c *peerConn, handle func(data []byte)) error {
+	lastActivity := time.Now()
+	c.ws.SetReadDeadline(p.keepalive.readDeadline(lastActivity, lastActivity))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(p.keepalive.readDeadline(time.Now(), lastActivity))
+	})
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if p.keepalive.IdleTimeout > 0 && time.Since(lastActivity) >= p.keepalive.IdleTimeout {
+					return errIdleTimeout
+				}
+				return errPongTimeout
+			}
+			return err
+		}
+		lastActivity = time.Now()
+		c.ws.SetReadDeadline(p.keepalive.readDeadline(lastActivity, lastActivity))
+		handle(data)
+	}
+}
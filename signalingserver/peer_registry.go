@@ -0,0 +1,69 @@
+package signalingserver
+
+import "sync"
+
+// peerRegistry is the concurrency-safe table of connected peers.
+type peerRegistry struct {
+	mu    sync.RWMutex
+	peers map[string]*peer
+}
+
+func newPeerRegistry() *peerRegistry {
+	return &peerRegistry{peers: make(map[string]*peer)}
+}
+
+// add registers p and reports false if its ID is already taken.
+func (r *peerRegistry) add(p *peer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.peers[p.id]; exist {
+		return false
+	}
+	r.peers[p.id] = p
+	return true
+}
+
+// remove unregisters p, unless its ID has since been taken by another peer.
+func (r *peerRegistry) remove(p *peer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.peers[p.id] != p {
+		return false
+	}
+	delete(r.peers, p.id)
+	return true
+}
+
+func (r *peerRegistry) get(id string) (*peer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, exist := r.peers[id]
+	return p, exist
+}
+
+func (r *peerRegistry) ids() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.peers))
+	for id := range r.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// all returns a snapshot of the registered peers, safe to iterate without the lock.
+func (r *peerRegistry) all() []*peer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	peers := make([]*peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func (r *peerRegistry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.peers)
+}
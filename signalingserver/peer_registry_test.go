@@ -0,0 +1,80 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// Every peer broadcasts and lists the peers at the same time, which exercises the
+// registry and the write pumps from hundreds of goroutines. Run with -race.
+func TestConcurrentDeliveryToManyPeers(t *testing.T) {
+	const peerCount = 200
+	s := NewSignalingServer(10, true, true)
+	url := newTestServer(t, s)
+
+	clients := make([]*testClient, peerCount)
+	ids := make([]string, peerCount)
+	for i := range clients {
+		clients[i] = dialLegacy(t, url)
+		ids[i] = clients[i].identify()
+	}
+	sort.Strings(ids)
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *testClient) {
+			defer wg.Done()
+			text, _ := json.Marshal(message.TextMessageContent{Title: "hello", Message: fmt.Sprint(i)})
+			if err := c.WriteJSON(message.Message{Kind: message.TextMessage, Reach: message.AllPeers, Content: text}); err != nil {
+				t.Errorf("peer %d: sending broadcast: %v", i, err)
+				return
+			}
+			if err := c.WriteJSON(message.Message{Kind: message.GetAllPeerIDs, Reach: message.Self, Content: json.RawMessage("{}")}); err != nil {
+				t.Errorf("peer %d: requesting peer IDs: %v", i, err)
+				return
+			}
+
+			senders := make(map[string]bool)
+			listed := false
+			for len(senders) < peerCount-1 || !listed {
+				msg, err := c.next(4 * receiveTimeout)
+				if err != nil {
+					t.Errorf("peer %d: got %d broadcasts, peer IDs listed %v: %v", i, len(senders), listed, err)
+					return
+				}
+				switch msg.Kind {
+				case message.TextMessage:
+					if senders[msg.Sender] {
+						t.Errorf("peer %d: broadcast of %s delivered twice", i, msg.Sender)
+					}
+					senders[msg.Sender] = true
+				case message.GetAllPeerIDs:
+					var content message.GetAllPeerIDsContent
+					if err := json.Unmarshal(msg.Content, &content); err != nil {
+						t.Errorf("peer %d: decoding peer IDs: %v", i, err)
+						return
+					}
+					sort.Strings(content.PeersIDs)
+					if fmt.Sprint(content.PeersIDs) != fmt.Sprint(ids) {
+						t.Errorf("peer %d: got %d peer IDs, want the %d connected peers", i, len(content.PeersIDs), peerCount)
+					}
+					listed = true
+				default:
+					t.Errorf("peer %d: unexpected %v message", i, msg.Kind)
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, c := range clients {
+		c.Close()
+	}
+	waitFor(t, "every peer to be unregistered", func() bool { return s.peers.len() == 0 })
+}
@@ -0,0 +1,184 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// peerSubscription is a live view of the peers a subscriber can see.
+type peerSubscription struct {
+	subscriber *peer
+	// room the subscription is scoped to, empty for the peers visible to the subscriber
+	room    string
+	version uint64
+	// peer IDs the subscriber was last told about
+	known map[string]struct{}
+}
+
+// presence tracks the peer list subscriptions. Updates are computed and queued under
+// one lock so every subscriber sees them in version order.
+type presence struct {
+	mu            sync.Mutex
+	subscriptions map[*peer]*peerSubscription
+}
+
+func newPresence() *presence {
+	return &presence{subscriptions: make(map[*peer]*peerSubscription)}
+}
+
+// peerIDs returns the IDs of scope, leaving out self unless the server is configured
+// to include it.
+func (s *SignalingServer) peerIDs(scope []*peer, self *peer) []string {
+	ids := make([]string, 0, len(scope))
+	for _, p := range scope {
+		if p != self || s.addSelfToGetPeerIDs {
+			ids = append(ids, p.id)
+		}
+	}
+	return ids
+}
+
+// subscriptionView returns the peer IDs sub currently covers.
+func (s *SignalingServer) subscriptionView(sub *peerSubscription) []string {
+	if sub.room != "" {
+		if !s.rooms.isMember(sub.room, sub.subscriber) {
+			return nil
+		}
+		return s.peerIDs(s.rooms.members(sub.room), sub.subscriber)
+	}
+	return s.peerIDs(s.visiblePeers(sub.subscriber), sub.subscriber)
+}
+
+// subscribePeers starts (or restarts) the peer list subscription of p and sends it the snapshot.
+func (s *SignalingServer) subscribePeers(p *peer, room string) {
+	s.presence.mu.Lock()
+	sub := &peerSubscription{subscriber: p, room: room, version: 1, known: make(map[string]struct{})}
+	ids := s.subscriptionView(sub)
+	for _, id := range ids {
+		sub.known[id] = struct{}{}
+	}
+	s.presence.subscriptions[p] = sub
+	err := s.enqueuePeerList(p, message.SubscribePeers, room, message.PeerListContent{Version: sub.version, PeersIDs: ids})
+	s.presence.mu.Unlock()
+	s.kickIfFull(p, err)
+}
+
+func (s *SignalingServer) unsubscribePeers(p *peer) {
+	s.presence.mu.Lock()
+	defer s.presence.mu.Unlock()
+	delete(s.presence.subscriptions, p)
+}
+
+// publishPresence tells the subscribers whose view changed peer joined or left since the
+// last update. It is called after changed registers, unregisters, or joins or leaves a room.
+func (s *SignalingServer) publishPresence(changed *peer) {
+	var slow []*peer
+	s.presence.mu.Lock()
+	// where changed is now, looked up once for every subscriber
+	current, exist := s.peers.get(changed.id)
+	registered := exist && current == changed
+	rooms := s.rooms.roomsOf(changed)
+	for subscriber, sub := range s.presence.subscriptions {
+		var joined, left []string
+		if subscriber == changed {
+			// the rooms of the subscriber itself decide what it sees
+			joined, left = s.refreshView(sub)
+		} else {
+			_, known := sub.known[changed.id]
+			switch visible := registered && sub.sees(s, rooms); {
+			case visible && !known:
+				sub.known[changed.id] = struct{}{}
+				joined = []string{changed.id}
+			case !visible && known:
+				delete(sub.known, changed.id)
+				left = []string{changed.id}
+			}
+		}
+		if len(joined) == 0 && len(left) == 0 {
+			continue
+		}
+		sub.version++
+		err := s.enqueuePeerList(subscriber, message.PeersChanged, sub.room, message.PeerListContent{Version: sub.version, Joined: joined, Left: left})
+		if errors.Is(err, errSendQueueFull) {
+			slow = append(slow, subscriber)
+		}
+	}
+	s.presence.mu.Unlock()
+	// kicking can unregister a peer, which publishes again
+	for _, p := range slow {
+		s.kickIfFull(p, errSendQueueFull)
+	}
+}
+
+// sees reports whether a peer in rooms is in the view of sub.
+func (sub *peerSubscription) sees(s *SignalingServer, rooms []string) bool {
+	if sub.room != "" {
+		return slices.Contains(rooms, sub.room) && s.rooms.isMember(sub.room, sub.subscriber)
+	}
+	return sharesRoom(rooms, s.rooms.roomsOf(sub.subscriber))
+}
+
+// refreshView recomputes the whole view of sub, and returns the peers that joined and
+// left it.
+func (s *SignalingServer) refreshView(sub *peerSubscription) (joined, left []string) {
+	view := make(map[string]struct{})
+	for _, id := range s.subscriptionView(sub) {
+		view[id] = struct{}{}
+	}
+	for id := range view {
+		if _, ok := sub.known[id]; !ok {
+			joined = append(joined, id)
+		}
+	}
+	for id := range sub.known {
+		if _, ok := view[id]; !ok {
+			left = append(left, id)
+		}
+	}
+	sub.known = view
+	return joined, left
+}
+
+// enqueuePeerList queues a peer list message for p without kicking it, as the caller
+// holds the presence lock.
+func (s *SignalingServer) enqueuePeerList(p *peer, kind message.MessageType, room string, content message.PeerListContent) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		p.logger.Error("Failed to marshal peer list", "error", err)
+		return nil
+	}
+	return p.enqueue(message.Message{Kind: kind, Reach: message.Self, Sender: "server", PeerID: p.id, Room: room, Content: data})
+}
+
+// kickIfFull disconnects p if err says its send queue is full: it is too slow to keep up.
+func (s *SignalingServer) kickIfFull(p *peer, err error) {
+	if errors.Is(err, errSendQueueFull) {
+		p.logger.Warn("Send queue is full, disconnecting peer")
+		s.kick(p, websocket.ClosePolicyViolation, "send queue full")
+	}
+}
+
+// announcePeer tells the peers that can see the newly connected p, on every node of the
+// cluster, that it joined.
+func (s *SignalingServer) announcePeer(p *peer) {
+	content, err := json.Marshal(message.PeerJoinedContent{PeerID: p.id})
+	if err != nil {
+		p.logger.Error("Failed to marshal peer announcement", "error", err)
+		return
+	}
+	announcement := message.Message{Kind: message.PeerJoined, Reach: message.AllPeers, Sender: "server", PeerID: p.id, Content: content}
+	for _, other := range s.visiblePeers(p) {
+		if other != p {
+			if err := s.send(other, announcement); err != nil {
+				other.logger.Warn("Failed to send peer announcement", "error", err)
+			}
+		}
+	}
+	s.broadcastCluster("", s.rooms.roomsOf(p), announcement)
+	s.publishPresence(p)
+}
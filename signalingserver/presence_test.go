@@ -0,0 +1,104 @@
+package signalingserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// receivePeerList returns the content of the next message of the given peer list kind.
+func (c *testClient) receivePeerList(kind message.MessageType) message.PeerListContent {
+	c.t.Helper()
+	var content message.PeerListContent
+	unmarshalContent(c.t, c.receiveKind(kind), &content)
+	return content
+}
+
+func TestPeerJoined(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	a := dialFeatures(t, url, message.FeaturePresence)
+	legacy := dialLegacy(t, url)
+	a.identify()
+	legacy.identify()
+
+	b := dialFeatures(t, url, message.FeaturePresence)
+	idB := b.identify()
+	var joined message.PeerJoinedContent
+	unmarshalContent(t, a.receiveKind(message.PeerJoined), &joined)
+	if joined.PeerID != idB {
+		t.Errorf("a was told %s joined, want %s", joined.PeerID, idB)
+	}
+	// the kind is unknown to clients that did not negotiate presence
+	legacy.expectNothing(100 * time.Millisecond)
+	b.expectNothing(100 * time.Millisecond)
+}
+
+func TestSubscribePeers(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	features := []string{message.FeaturePresence, message.FeatureRooms}
+	a := dialFeatures(t, url, features...)
+	b := dialFeatures(t, url, features...)
+	idB := b.identify()
+
+	a.send(message.Message{Kind: message.SubscribePeers, Reach: message.Self})
+	snapshot := a.receivePeerList(message.SubscribePeers)
+	if snapshot.Version != 1 || fmt.Sprint(snapshot.PeersIDs) != fmt.Sprint([]string{idB}) {
+		t.Fatalf("got snapshot %+v, want version 1 listing b", snapshot)
+	}
+
+	c := dialFeatures(t, url, features...)
+	idC := c.identify()
+	if diff := a.receivePeerList(message.PeersChanged); diff.Version != 2 || fmt.Sprint(diff.Joined) != fmt.Sprint([]string{idC}) || len(diff.Left) != 0 {
+		t.Errorf("got %+v, want version 2 with c joined", diff)
+	}
+	b.Close()
+	if diff := a.receivePeerList(message.PeersChanged); diff.Version != 3 || fmt.Sprint(diff.Left) != fmt.Sprint([]string{idB}) || len(diff.Joined) != 0 {
+		t.Errorf("got %+v, want version 3 with b left", diff)
+	}
+
+	// joining a room narrows the view of the subscriber to the room
+	c.joinRoom("r")
+	if diff := a.receivePeerList(message.PeersChanged); diff.Version != 4 || fmt.Sprint(diff.Left) != fmt.Sprint([]string{idC}) {
+		t.Errorf("got %+v, want version 4 with c left", diff)
+	}
+	// the update goes out before the confirmation
+	a.sendContent(message.JoinRoom, message.Self, message.JoinRoomContent{Room: "r"})
+	if diff := a.receivePeerList(message.PeersChanged); diff.Version != 5 || fmt.Sprint(diff.Joined) != fmt.Sprint([]string{idC}) {
+		t.Errorf("got %+v, want version 5 with c joined", diff)
+	}
+
+	a.send(message.Message{Kind: message.UnsubscribePeers, Reach: message.Self})
+	a.identify()
+	c.sendContent(message.LeaveRoom, message.Self, message.LeaveRoomContent{Room: "r"})
+	a.receiveKind(message.LeaveRoom)
+	a.expectNothing(100 * time.Millisecond)
+}
+
+func TestSubscribePeersInRoom(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	features := []string{message.FeaturePresence, message.FeatureRooms, message.FeatureErrors}
+	a := dialFeatures(t, url, features...)
+	b := dialFeatures(t, url, features...)
+	idB := b.identify()
+
+	a.send(message.Message{Kind: message.SubscribePeers, Reach: message.Self, Room: "r"})
+	if content := a.receiveError(); content.Code != message.ErrorNotInRoom {
+		t.Errorf("subscribing to a room of others: got error %v, want %v", content.Code, message.ErrorNotInRoom)
+	}
+
+	a.joinRoom("r")
+	a.send(message.Message{Kind: message.SubscribePeers, Reach: message.Self, Room: "r"})
+	if snapshot := a.receivePeerList(message.SubscribePeers); snapshot.Version != 1 || len(snapshot.PeersIDs) != 0 {
+		t.Errorf("got snapshot %+v, want version 1 with no peers", snapshot)
+	}
+	b.joinRoom("r")
+	diff := a.receivePeerList(message.PeersChanged)
+	if diff.Version != 2 || fmt.Sprint(diff.Joined) != fmt.Sprint([]string{idB}) {
+		t.Errorf("got %+v, want version 2 with b joined", diff)
+	}
+}
@@ -0,0 +1,171 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// clientProtocol is what the client of a peer understands. It is never modified, changes
+// replace it as a whole.
+type clientProtocol struct {
+	version  int
+	features map[string]struct{}
+}
+
+// legacyProtocol is assumed for clients until they send Hello.
+var legacyProtocol = &clientProtocol{version: 1}
+
+func (c *clientProtocol) has(feature string) bool {
+	_, ok := c.features[feature]
+	return ok
+}
+
+// with returns c with feature added.
+func (c *clientProtocol) with(feature string) *clientProtocol {
+	features := make(map[string]struct{}, len(c.features)+1)
+	for f := range c.features {
+		features[f] = struct{}{}
+	}
+	features[feature] = struct{}{}
+	return &clientProtocol{version: c.version, features: features}
+}
+
+// features returns the features the server offers.
+func (s *SignalingServer) features() []string {
+	features := []string{
+		message.FeatureRooms,
+		message.FeaturePresence,
+		message.FeatureMetadata,
+		message.FeatureErrors,
+		message.FeatureAcks,
+		message.FeatureShutdown,
+	}
+	if s.resumeGrace > 0 {
+		features = append(features, message.FeatureResume)
+	}
+	if s.storeAndForward != nil {
+		features = append(features, message.FeatureStoreAndForward)
+	}
+	if s.rateLimits != nil {
+		features = append(features, message.FeatureRateLimits)
+	}
+	for _, kind := range message.CustomKinds() {
+		features = append(features, kind.Feature())
+	}
+	return features
+}
+
+// handshake agrees with p on the protocol version and features it asked for in Hello,
+// and answers with Welcome.
+func (s *SignalingServer) handshake(p *peer, msg message.Message) {
+	var hello message.HandshakeContent
+	if err := json.Unmarshal(msg.Content, &hello); err != nil || hello.Version < 1 {
+		s.sendError(p, msg.ID, message.ErrorInvalidMessage, "Unsupported protocol version")
+		return
+	}
+	offered := s.features()
+	agreed := &clientProtocol{version: min(hello.Version, message.ProtocolVersion), features: make(map[string]struct{})}
+	var features []string
+	for _, feature := range offered {
+		if len(hello.Features) == 0 || slices.Contains(hello.Features, feature) {
+			agreed.features[feature] = struct{}{}
+			features = append(features, feature)
+		}
+	}
+	p.protocol.Store(agreed)
+	p.logger.Info("Protocol agreed", "version", agreed.version, "features", features)
+
+	content, err := json.Marshal(message.HandshakeContent{Version: agreed.version, Features: features, PeerID: p.id})
+	if err != nil {
+		p.logger.Error("Failed to marshal welcome", "error", err)
+		return
+	}
+	welcome := message.Message{ID: msg.ID, Kind: message.Welcome, Reach: message.Self, Sender: "server", PeerID: p.id, Content: content}
+	if err := s.send(p, welcome); err != nil {
+		p.logger.Warn("Failed to send message to self", "error", err)
+	}
+}
+
+// noteFeatures records the features a message from p uses: a client that sends a kind
+// understands the answers of that kind, whatever it agreed on.
+func (p *peer) noteFeatures(msg message.Message) {
+	for _, feature := range []string{msg.Kind.Feature(), reachFeature(msg.Reach)} {
+		if feature == "" {
+			continue
+		}
+		for {
+			current := p.protocol.Load()
+			if current.has(feature) || p.protocol.CompareAndSwap(current, current.with(feature)) {
+				break
+			}
+		}
+	}
+}
+
+func reachFeature(reach message.ReachType) string {
+	if reach == message.Room {
+		return message.FeatureRooms
+	}
+	return ""
+}
+
+// adapt rewrites msg into something the client of p understands, and reports false
+// if the client would not understand it at all.
+func (p *peer) adapt(msg message.Message) (message.Message, bool) {
+	protocol := p.protocol.Load()
+	if feature := msg.Kind.Feature(); feature != "" && !protocol.has(feature) {
+		text, ok := legacyText(msg)
+		if !ok {
+			return msg, false
+		}
+		content, err := json.Marshal(text)
+		if err != nil {
+			return msg, false
+		}
+		msg.Kind = message.TextMessage
+		msg.Content = content
+	}
+	if msg.Reach == message.Room && !protocol.has(message.FeatureRooms) {
+		msg.Reach = message.AllPeers
+	}
+	return msg, true
+}
+
+// legacyText returns the text message that told clients predating the kind of msg the
+// same thing, and reports false if there was none.
+func legacyText(msg message.Message) (message.TextMessageContent, bool) {
+	switch msg.Kind {
+	case message.Error:
+		// errors used to be text messages titled "error"
+		var content message.ErrorContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return message.TextMessageContent{}, false
+		}
+		return message.TextMessageContent{Title: "error", Message: content.Message}, true
+	case message.Ack:
+		// delivery outcomes used to be text messages titled with the status, like "queued"
+		var content message.AckContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return message.TextMessageContent{}, false
+		}
+		return message.TextMessageContent{Title: content.Status.String(), Message: content.Message}, true
+	case message.ServerShutdown:
+		var content message.ServerShutdownContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return message.TextMessageContent{}, false
+		}
+		text := content.Message
+		if text == "" {
+			text = "Server is shutting down"
+		}
+		if content.ReconnectAfterMs > 0 {
+			text += fmt.Sprintf(" (reconnect after %v)", time.Duration(content.ReconnectAfterMs)*time.Millisecond)
+		}
+		return message.TextMessageContent{Title: "shutdown", Message: text}, true
+	}
+	return message.TextMessageContent{}, false
+}
@@ -0,0 +1,112 @@
+package signalingserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// hello sends Hello with version and features, and returns the content of the Welcome.
+func (c *testClient) hello(version int, features ...string) message.HandshakeContent {
+	c.t.Helper()
+	c.sendContent(message.Hello, message.Self, message.HandshakeContent{Version: version, Features: features})
+	var welcome message.HandshakeContent
+	unmarshalContent(c.t, c.receiveKind(message.Welcome), &welcome)
+	return welcome
+}
+
+func TestHandshake(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithSessionResumption(time.Minute))
+	url := newTestServer(t, s)
+
+	c := dialLegacy(t, url)
+	id := c.identify()
+	welcome := c.hello(message.ProtocolVersion+1, message.FeatureRooms, "teleportation")
+	if welcome.Version != message.ProtocolVersion || fmt.Sprint(welcome.Features) != fmt.Sprint([]string{message.FeatureRooms}) || welcome.PeerID != id {
+		t.Errorf("got %+v, want the current version, rooms only and the peer ID", welcome)
+	}
+
+	all := dialLegacy(t, url).hello(message.ProtocolVersion)
+	if fmt.Sprint(all.Features) != fmt.Sprint(s.features()) {
+		t.Errorf("got features %v, want all of %v", all.Features, s.features())
+	}
+	resume := false
+	for _, feature := range all.Features {
+		resume = resume || feature == message.FeatureResume
+	}
+	if !resume {
+		t.Errorf("features %v miss the resume feature of the server", all.Features)
+	}
+
+	bad := dialLegacy(t, url)
+	bad.sendContent(message.Hello, message.Self, message.HandshakeContent{Version: 0})
+	var content message.TextMessageContent
+	if msg := bad.receive(); msg.Kind != message.TextMessage {
+		t.Errorf("got %v, want the error as a text message", msg.Kind)
+	} else if unmarshalContent(t, msg, &content); content.Title != "error" {
+		t.Errorf("got %+v, want an error", content)
+	}
+}
+
+func TestLegacyClients(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	legacy := dialLegacy(t, url)
+	roomsOnly := dialFeatures(t, url, message.FeatureRooms)
+	legacy.identify()
+	roomsOnly.identify()
+
+	// the kinds of features a client did not agree on are not sent to it
+	dial(t, url).identify()
+	legacy.expectNothing(100 * time.Millisecond)
+	roomsOnly.expectNothing(100 * time.Millisecond)
+
+	// a legacy client that uses rooms is sent room messages
+	legacy.joinRoom("r")
+	roomsOnly.joinRoom("r")
+	if msg := legacy.receive(); msg.Kind != message.JoinRoom {
+		t.Errorf("legacy client got %v, want the JoinRoom of the other peer", msg.Kind)
+	}
+	roomsOnly.send(message.Message{Kind: message.TextMessage, Reach: message.Room, Room: "r", Content: []byte(`{"message":"hi"}`)})
+	if msg := legacy.receive(); msg.Kind != message.TextMessage || msg.Reach != message.Room || msg.Room != "r" {
+		t.Errorf("legacy client got %+v, want the room message", msg)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	legacy := newPeer("legacy", 1, DefaultKeepaliveConfig())
+	errorsOnly := newPeer("errors", 1, DefaultKeepaliveConfig())
+	errorsOnly.protocol.Store(legacyProtocol.with(message.FeatureErrors))
+
+	roomMessage := message.Message{Kind: message.TextMessage, Reach: message.Room, Room: "r", Content: []byte(`{}`)}
+	errorMessage := message.Message{Kind: message.Error, Reach: message.Self, Content: []byte(`{"code":"unknown_peer","message":"gone"}`)}
+	ackMessage := message.Message{Kind: message.Ack, Reach: message.Self, Content: []byte(`{"messageID":"m1","status":"queued","message":"held"}`)}
+	tests := []struct {
+		name    string
+		p       *peer
+		msg     message.Message
+		sent    bool
+		kind    message.MessageType
+		reach   message.ReachType
+		content string
+	}{
+		{"room reach", legacy, roomMessage, true, message.TextMessage, message.AllPeers, `{}`},
+		{"legacy error", legacy, errorMessage, true, message.TextMessage, message.Self, `{"title":"error","message":"gone"}`},
+		{"error", errorsOnly, errorMessage, true, message.Error, message.Self, string(errorMessage.Content)},
+		{"legacy ack", legacy, ackMessage, true, message.TextMessage, message.Self, `{"title":"queued","message":"held"}`},
+		{"unknown kind", legacy, message.Message{Kind: message.PeerJoined, Reach: message.AllPeers}, false, 0, 0, ""},
+		{"known kind", legacy, message.Message{Kind: message.Offer, Reach: message.OnePeer, Content: []byte(`{}`)}, true, message.Offer, message.OnePeer, `{}`},
+	}
+	for _, test := range tests {
+		msg, sent := test.p.adapt(test.msg)
+		if sent != test.sent {
+			t.Errorf("%s: sent is %v, want %v", test.name, sent, test.sent)
+			continue
+		}
+		if sent && (msg.Kind != test.kind || msg.Reach != test.reach || string(msg.Content) != test.content) {
+			t.Errorf("%s: got %v %v %s, want %v %v %s", test.name, msg.Kind, msg.Reach, msg.Content, test.kind, test.reach, test.content)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package signalingserver
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// RateLimit is a token bucket: a peer can send Burst messages at once, then Rate messages
+// per second. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig sets the limits applied to the messages of each peer. A message must
+// fit in the Default bucket, the bucket of its kind and the bucket of its reach.
+type RateLimitConfig struct {
+	Default RateLimit
+	Kinds   map[message.MessageType]RateLimit
+	Reaches map[message.ReachType]RateLimit
+
+	// Peers that exceed a limit this many times within ViolationWindow are disconnected
+	// with a policy violation, 0 never disconnects them
+	MaxViolations   int
+	ViolationWindow time.Duration
+}
+
+// DefaultRateLimitConfig lets ICE candidates burst, as a peer gathers many at once, and
+// keeps text messages and broadcasts, which fan out to every peer, strict.
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Default: RateLimit{Rate: 50, Burst: 100},
+		Kinds: map[message.MessageType]RateLimit{
+			message.ICECandidate: {Rate: 20, Burst: 100},
+			message.TextMessage:  {Rate: 2, Burst: 5},
+		},
+		Reaches: map[message.ReachType]RateLimit{
+			message.AllPeers: {Rate: 2, Burst: 10},
+			message.Room:     {Rate: 5, Burst: 20},
+		},
+		MaxViolations:   20,
+		ViolationWindow: time.Minute,
+	}
+}
+
+// WithRateLimits limits how many messages each peer can send. Messages over a limit are
+// dropped and the sender gets an error. Rate limiting is disabled by default.
+func WithRateLimits(config RateLimitConfig) Option {
+	return func(s *SignalingServer) {
+		if config.ViolationWindow <= 0 {
+			config.ViolationWindow = time.Minute
+		}
+		s.rateLimits = &config
+	}
+}
+
+// RateLimitStats counts the messages checked against rate limits.
+type RateLimitStats struct {
+	// Messages within the limits
+	Allowed uint64
+	// Messages dropped for exceeding a limit
+	Limited uint64
+	// Peers disconnected for exceeding limits too often
+	Disconnected uint64
+}
+
+// rateLimitCounters are the server wide RateLimitStats.
+type rateLimitCounters struct {
+	allowed      atomic.Uint64
+	limited      atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+// RateLimitStats returns the rate limiting counters of all peers since the server started.
+func (s *SignalingServer) RateLimitStats() RateLimitStats {
+	return RateLimitStats{
+		Allowed:      s.rateLimitCounters.allowed.Load(),
+		Limited:      s.rateLimitCounters.limited.Load(),
+		Disconnected: s.rateLimitCounters.disconnected.Load(),
+	}
+}
+
+// PeerRateLimitStats returns the rate limiting counters of the connected peer peerID.
+// Disconnected is 0 as the peer is still connected.
+func (s *SignalingServer) PeerRateLimitStats(peerID string) (RateLimitStats, bool) {
+	p, exist := s.peers.get(peerID)
+	if !exist || p.limiter == nil {
+		return RateLimitStats{}, false
+	}
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+	return RateLimitStats{Allowed: p.limiter.allowed, Limited: p.limiter.limited}, true
+}
+
+// allowMessage checks msg against the rate limits of p. A message over a limit is answered
+// with an error, and p is disconnected if it keeps exceeding its limits.
+func (s *SignalingServer) allowMessage(p *peer, msg message.Message) bool {
+	if p.limiter == nil {
+		return true
+	}
+	ok, retryAfter, abusive := p.limiter.allow(msg.Kind, msg.Reach)
+	if ok {
+		s.rateLimitCounters.allowed.Add(1)
+		return true
+	}
+	s.rateLimitCounters.limited.Add(1)
+	if p.kicked.Load() {
+		return false
+	}
+	if abusive {
+		p.logger.Warn("Peer keeps exceeding its rate limits, disconnecting it")
+		s.rateLimitCounters.disconnected.Add(1)
+		s.kick(p, websocket.ClosePolicyViolation, "rate limit exceeded")
+		return false
+	}
+	s.sendErrorContent(p, message.ErrorContent{
+		Code:         message.ErrorRateLimited,
+		Message:      fmt.Sprintf("Rate limit exceeded, retry in %v", retryAfter.Round(time.Millisecond)),
+		RequestID:    msg.ID,
+		RetryAfterMs: (retryAfter + time.Millisecond - 1).Milliseconds(),
+	})
+	return false
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time since the last call and takes one token. When
+// the bucket is empty it returns how long until a token is available.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	burst := float64(max(limit.Burst, 1))
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimiter holds the token buckets of one peer.
+type rateLimiter struct {
+	config *RateLimitConfig
+
+	mu         sync.Mutex
+	general    tokenBucket
+	kinds      map[message.MessageType]*tokenBucket
+	reaches    map[message.ReachType]*tokenBucket
+	violations []time.Time
+	allowed    uint64
+	limited    uint64
+}
+
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:  config,
+		kinds:   make(map[message.MessageType]*tokenBucket),
+		reaches: make(map[message.ReachType]*tokenBucket),
+	}
+}
+
+// allow reports whether a message of kind and reach is within the limits. Otherwise it
+// also returns how long the peer should wait and whether it exceeded limits too often.
+func (l *rateLimiter) allow(kind message.MessageType, reach message.ReachType) (ok bool, retryAfter time.Duration, abusive bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// a rejected message costs nothing: tokens taken before a bucket rejects it are put back
+	type check struct {
+		bucket *tokenBucket
+		limit  RateLimit
+	}
+	var checks []check
+	if l.config.Default.Rate > 0 {
+		checks = append(checks, check{&l.general, l.config.Default})
+	}
+	if limit, exist := l.config.Kinds[kind]; exist && limit.Rate > 0 {
+		if l.kinds[kind] == nil {
+			l.kinds[kind] = &tokenBucket{}
+		}
+		checks = append(checks, check{l.kinds[kind], limit})
+	}
+	if limit, exist := l.config.Reaches[reach]; exist && limit.Rate > 0 {
+		if l.reaches[reach] == nil {
+			l.reaches[reach] = &tokenBucket{}
+		}
+		checks = append(checks, check{l.reaches[reach], limit})
+	}
+	snapshot := make([]tokenBucket, len(checks))
+	for i, c := range checks {
+		snapshot[i] = *c.bucket
+	}
+	for i, c := range checks {
+		if allowed, wait := c.bucket.take(c.limit, now); !allowed {
+			for j := range i {
+				*checks[j].bucket = snapshot[j]
+			}
+			l.limited++
+			return false, wait, l.violated(now)
+		}
+	}
+	l.allowed++
+	return true, 0, false
+}
+
+// violated records a violation at now and reports whether the peer reached MaxViolations
+// within the violation window.
+func (l *rateLimiter) violated(now time.Time) bool {
+	if l.config.MaxViolations <= 0 {
+		return false
+	}
+	windowStart := now.Add(-l.config.ViolationWindow)
+	kept := l.violations[:0]
+	for _, at := range l.violations {
+		if at.After(windowStart) {
+			kept = append(kept, at)
+		}
+	}
+	l.violations = append(kept, now)
+	return len(l.violations) >= l.config.MaxViolations
+}
@@ -0,0 +1,101 @@
+package signalingserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{Rate: 10, Burst: 2}
+	var bucket tokenBucket
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.take(limit, now); !ok {
+			t.Fatalf("message %d of the burst rejected", i)
+		}
+	}
+	ok, wait := bucket.take(limit, now)
+	if ok || wait != 100*time.Millisecond {
+		t.Errorf("message past the burst: got %v, wait %v, want a rejection for 100ms", ok, wait)
+	}
+	if ok, _ := bucket.take(limit, now.Add(100*time.Millisecond)); !ok {
+		t.Error("message rejected once a token was refilled")
+	}
+	// refilling stops at the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.take(limit, now); ok != (i < 2) {
+			t.Errorf("message %d after an hour: got %v", i, ok)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(&RateLimitConfig{
+		Kinds:         map[message.MessageType]RateLimit{message.TextMessage: {Rate: 1, Burst: 1}},
+		Reaches:       map[message.ReachType]RateLimit{message.AllPeers: {Rate: 1, Burst: 2}},
+		MaxViolations: 3, ViolationWindow: time.Minute,
+	})
+	if ok, _, _ := limiter.allow(message.TextMessage, message.AllPeers); !ok {
+		t.Fatal("first text broadcast rejected")
+	}
+	// rejected by the kind bucket, without taking from the reach bucket
+	if ok, retryAfter, abusive := limiter.allow(message.TextMessage, message.AllPeers); ok || retryAfter <= 0 || abusive {
+		t.Errorf("second text broadcast: got %v, retry after %v, abusive %v", ok, retryAfter, abusive)
+	}
+	if ok, _, _ := limiter.allow(message.Offer, message.AllPeers); !ok {
+		t.Error("offer broadcast rejected, the reach bucket has a token left")
+	}
+	if ok, _, _ := limiter.allow(message.ICECandidate, message.OnePeer); !ok {
+		t.Error("unlimited kind and reach rejected")
+	}
+	if _, _, abusive := limiter.allow(message.Offer, message.AllPeers); abusive {
+		t.Error("second violation reported as abusive")
+	}
+	if _, _, abusive := limiter.allow(message.Offer, message.AllPeers); !abusive {
+		t.Error("third violation not reported as abusive")
+	}
+	if limiter.allowed != 3 || limiter.limited != 3 {
+		t.Errorf("counted %d allowed and %d limited, want 3 and 3", limiter.allowed, limiter.limited)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithRateLimits(RateLimitConfig{
+		Kinds:         map[message.MessageType]RateLimit{message.TextMessage: {Rate: 0.1, Burst: 2}},
+		MaxViolations: 3,
+	}))
+	url := newTestServer(t, s)
+	a, b := dial(t, url), dial(t, url)
+	idA := a.identify()
+	b.identify()
+
+	for i := 0; i < 2; i++ {
+		a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "hi"})
+		b.receiveKind(message.TextMessage)
+	}
+	a.send(message.Message{ID: "text-3", Kind: message.TextMessage, Reach: message.AllPeers, Content: []byte(`{"message":"hi"}`)})
+	content := a.receiveError()
+	if content.Code != message.ErrorRateLimited || content.RequestID != "text-3" || content.RetryAfterMs <= 0 {
+		t.Errorf("got error %+v, want a rate limit of text-3 with a retry delay", content)
+	}
+	b.expectNothing(100 * time.Millisecond)
+	// Hello and IdentifySelf count too
+	if stats, ok := s.PeerRateLimitStats(idA); !ok || stats.Allowed != 4 || stats.Limited != 1 {
+		t.Errorf("stats of a are %+v, %v, want 4 allowed and 1 limited", stats, ok)
+	}
+
+	for i := 0; i < 2; i++ {
+		a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "hi"})
+	}
+	if err := a.closed(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("repeat offender closed with %v, want a policy violation", err)
+	}
+	waitFor(t, "a to be unregistered", func() bool { return s.peers.len() == 1 })
+	if stats := s.RateLimitStats(); stats.Limited != 3 || stats.Disconnected != 1 {
+		t.Errorf("server stats are %+v, want 3 limited and 1 disconnected", stats)
+	}
+}
@@ -0,0 +1,466 @@
+// Package redisbroker implements a signalingserver.Broker on Redis: peers are kept in a
+// hash, nodes keep a key alive while they are subscribed, and envelopes go through
+// pub/sub channels. Envelopes published while a node is reconnecting to Redis are lost.
+// It needs a single Redis server, Redis Cluster is not supported.
+package redisbroker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver"
+)
+
+// ErrClosed is returned by the calls made on a closed Broker.
+var ErrClosed = errors.New("redis broker is closed")
+
+// How long a subscription waits before reconnecting after losing its connection
+const retryDelay = time.Second
+
+type Config struct {
+	// Address of the Redis server, host:port
+	Addr string
+
+	// Credentials sent with AUTH, none when Password is empty
+	Username string
+	Password string
+
+	// Database selected on every connection
+	DB int
+
+	// TLS settings, nil to connect over plain TCP
+	TLSConfig *tls.Config
+
+	// Prefix of the keys and channels of the cluster, "signalingserver" by default.
+	// Clusters sharing a Redis server need different prefixes.
+	Prefix string
+
+	// How long a node that stopped refreshing its key stays a member of the cluster,
+	// 15 seconds by default. Nodes refresh it three times per NodeTTL.
+	NodeTTL time.Duration
+
+	// Idle connections kept for commands, 8 by default
+	PoolSize int
+
+	// Time limit of dialing, and of commands whose context has no deadline, 5 seconds by default
+	Timeout time.Duration
+
+	// Logger of connection failures, slog.Default() when nil
+	Logger *slog.Logger
+}
+
+// Broker is a signalingserver.Broker on Redis.
+type Broker struct {
+	config Config
+
+	// idle command connections
+	idle chan *conn
+
+	mu     sync.Mutex
+	nodes  map[string]bool
+	closed bool
+}
+
+var _ signalingserver.Broker = (*Broker)(nil)
+
+// New returns a Broker connecting to Redis as config says. Connections are made when
+// they are first needed.
+func New(config Config) *Broker {
+	if config.Prefix == "" {
+		config.Prefix = "signalingserver"
+	}
+	if config.NodeTTL <= 0 {
+		config.NodeTTL = 15 * time.Second
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = 8
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	return &Broker{
+		config: config,
+		idle:   make(chan *conn, config.PoolSize),
+		nodes:  make(map[string]bool),
+	}
+}
+
+func (b *Broker) peersKey() string {
+	return b.config.Prefix + ":peers"
+}
+
+// nodeKey is the key node keeps alive while it is subscribed.
+func (b *Broker) nodeKey(node string) string {
+	return b.config.Prefix + ":node:" + node
+}
+
+func (b *Broker) broadcastChannel() string {
+	return b.config.Prefix + ":broadcast"
+}
+
+func (b *Broker) inboxChannel(node string) string {
+	return b.config.Prefix + ":inbox:" + node
+}
+
+// do runs a command on a pooled connection. A command that fails on an idle connection,
+// which Redis may have closed in the meantime, is tried again on a new one.
+func (b *Broker) do(ctx context.Context, args ...string) (any, error) {
+	c, pooled, err := b.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(b.config.Timeout)
+	}
+	c.SetDeadline(deadline)
+	reply, err := c.do(args...)
+	if err != nil && !isError(err) {
+		c.Close()
+		if pooled {
+			return b.doOnNewConn(ctx, deadline, args)
+		}
+		return nil, err
+	}
+	b.put(c)
+	return reply, err
+}
+
+func (b *Broker) doOnNewConn(ctx context.Context, deadline time.Time, args []string) (any, error) {
+	c, err := dial(ctx, &b.config)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(deadline)
+	reply, err := c.do(args...)
+	if err != nil && !isError(err) {
+		c.Close()
+		return nil, err
+	}
+	b.put(c)
+	return reply, err
+}
+
+// get returns an idle connection, or a new one, and whether it was idle.
+func (b *Broker) get(ctx context.Context) (*conn, bool, error) {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return nil, false, ErrClosed
+	}
+	select {
+	case c := <-b.idle:
+		return c, true, nil
+	default:
+		c, err := dial(ctx, &b.config)
+		return c, false, err
+	}
+}
+
+func (b *Broker) put(c *conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		c.Close()
+		return
+	}
+	select {
+	case b.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+// Close closes the idle connections. Subscriptions end with their context.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	for {
+		select {
+		case c := <-b.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// registerScript sets the record of a peer unless a peer of another live node has its ID.
+// ARGV: peer ID, node, record, prefix of the node keys.
+const registerScript = `
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if current then
+	local node = cjson.decode(current).node
+	if node ~= ARGV[2] and redis.call('EXISTS', ARGV[4] .. node) == 1 then
+		return 0
+	end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+return 1
+`
+
+// unregisterScript deletes the record of a peer if it belongs to the node.
+// ARGV: peer ID, node.
+const unregisterScript = `
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if current and cjson.decode(current).node == ARGV[2] then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 1
+`
+
+func (b *Broker) Register(ctx context.Context, peer signalingserver.ClusterPeer) (bool, error) {
+	record, err := json.Marshal(peer)
+	if err != nil {
+		return false, err
+	}
+	reply, err := b.do(ctx, "EVAL", registerScript, "1", b.peersKey(), peer.ID, peer.Node, string(record), b.nodeKey(""))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (b *Broker) Unregister(ctx context.Context, peer signalingserver.ClusterPeer) error {
+	_, err := b.do(ctx, "EVAL", unregisterScript, "1", b.peersKey(), peer.ID, peer.Node)
+	return err
+}
+
+func (b *Broker) Lookup(ctx context.Context, peerID string) (signalingserver.ClusterPeer, bool, error) {
+	reply, err := b.do(ctx, "HGET", b.peersKey(), peerID)
+	if err != nil || reply == nil {
+		return signalingserver.ClusterPeer{}, false, err
+	}
+	peer, err := decodePeer(reply)
+	if err != nil {
+		return signalingserver.ClusterPeer{}, false, err
+	}
+	live, err := b.live(ctx, peer.Node)
+	if err != nil || !live {
+		return signalingserver.ClusterPeer{}, false, err
+	}
+	return peer, true, nil
+}
+
+func (b *Broker) Peers(ctx context.Context) ([]signalingserver.ClusterPeer, error) {
+	reply, err := b.do(ctx, "HGETALL", b.peersKey())
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected HGETALL reply %T", reply)
+	}
+	liveNodes := make(map[string]bool)
+	var peers []signalingserver.ClusterPeer
+	for i := 1; i < len(fields); i += 2 {
+		peer, err := decodePeer(fields[i])
+		if err != nil {
+			return nil, err
+		}
+		live, checked := liveNodes[peer.Node]
+		if !checked {
+			if live, err = b.live(ctx, peer.Node); err != nil {
+				return nil, err
+			}
+			liveNodes[peer.Node] = live
+		}
+		if live {
+			peers = append(peers, peer)
+		} else {
+			// the node went away without unregistering its peers
+			b.Unregister(ctx, peer)
+		}
+	}
+	return peers, nil
+}
+
+// live reports whether node is still a member of the cluster.
+func (b *Broker) live(ctx context.Context, node string) (bool, error) {
+	reply, err := b.do(ctx, "EXISTS", b.nodeKey(node))
+	return reply == int64(1), err
+}
+
+func decodePeer(reply any) (signalingserver.ClusterPeer, error) {
+	var peer signalingserver.ClusterPeer
+	record, ok := reply.(string)
+	if !ok {
+		return peer, fmt.Errorf("redis: unexpected peer record %T", reply)
+	}
+	if err := json.Unmarshal([]byte(record), &peer); err != nil {
+		return peer, fmt.Errorf("decoding peer record: %w", err)
+	}
+	return peer, nil
+}
+
+func (b *Broker) Publish(ctx context.Context, env signalingserver.Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("marshaling envelope: %w", err)
+	}
+	channel := b.broadcastChannel()
+	if env.To != "" {
+		channel = b.inboxChannel(env.To)
+	}
+	_, err = b.do(ctx, "PUBLISH", channel, string(data))
+	return err
+}
+
+// Subscribe marks node alive and subscribes it to its inbox and to the broadcasts. It
+// returns once both are done, then keeps node alive and reconnects on its own until ctx
+// is canceled.
+func (b *Broker) Subscribe(ctx context.Context, node string, handle func(signalingserver.Envelope)) error {
+	b.mu.Lock()
+	if b.nodes[node] {
+		b.mu.Unlock()
+		return signalingserver.ErrAlreadySubscribed
+	}
+	b.nodes[node] = true
+	b.mu.Unlock()
+
+	if err := b.keepAlive(ctx, node); err != nil {
+		b.unsubscribed(node)
+		return fmt.Errorf("marking node alive: %w", err)
+	}
+	c, err := b.subscribe(ctx, node)
+	if err != nil {
+		b.unsubscribed(node)
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(b.config.NodeTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				cleanupCtx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+				b.do(cleanupCtx, "DEL", b.nodeKey(node))
+				cancel()
+				b.unsubscribed(node)
+				return
+			case <-ticker.C:
+				if err := b.keepAlive(ctx, node); err != nil && ctx.Err() == nil {
+					b.config.Logger.Warn("Failed to keep node alive in Redis", "node", node, "error", err)
+				}
+			}
+		}
+	}()
+	go b.receive(ctx, node, c, handle)
+	return nil
+}
+
+func (b *Broker) unsubscribed(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.nodes, node)
+}
+
+func (b *Broker) keepAlive(ctx context.Context, node string) error {
+	ttl := fmt.Sprint(b.config.NodeTTL.Milliseconds())
+	_, err := b.do(ctx, "SET", b.nodeKey(node), "1", "PX", ttl)
+	return err
+}
+
+// subscribe opens the subscriber connection of node.
+func (b *Broker) subscribe(ctx context.Context, node string) (*conn, error) {
+	c, err := dial(ctx, &b.config)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(b.config.Timeout))
+	if err := c.writeCommand("SUBSCRIBE", b.inboxChannel(node), b.broadcastChannel()); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// one confirmation per channel
+	for range 2 {
+		if _, err := c.readReply(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("subscribing: %w", err)
+		}
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// receive hands the envelopes read from c to handle, reconnecting when c fails, until
+// ctx is canceled.
+func (b *Broker) receive(ctx context.Context, node string, c *conn, handle func(signalingserver.Envelope)) {
+	for {
+		err := b.readEnvelopes(ctx, node, c, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		b.config.Logger.Warn("Lost the Redis subscription, reconnecting", "node", node, "error", err)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			if c, err = b.subscribe(ctx, node); err == nil {
+				break
+			}
+			b.config.Logger.Warn("Failed to subscribe to Redis", "node", node, "error", err)
+		}
+	}
+}
+
+// readEnvelopes reads from the subscriber connection c until it fails or ctx is canceled.
+// The connection is pinged every NodeTTL/3 so a dead one is noticed.
+func (b *Broker) readEnvelopes(ctx context.Context, node string, c *conn, handle func(signalingserver.Envelope)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(b.config.NodeTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				c.Close()
+				return
+			case <-ctx.Done():
+				c.Close()
+				return
+			case <-ticker.C:
+				// the reader only writes again on another connection
+				c.SetWriteDeadline(time.Now().Add(b.config.Timeout))
+				c.writeCommand("PING")
+			}
+		}
+	}()
+	for {
+		c.SetReadDeadline(time.Now().Add(b.config.NodeTTL))
+		reply, err := c.readReply()
+		if err != nil {
+			return err
+		}
+		// pushed messages are [message, channel, payload]; confirmations and pongs are ignored
+		fields, ok := reply.([]any)
+		if !ok || len(fields) != 3 || fields[0] != "message" {
+			continue
+		}
+		payload, _ := fields[2].(string)
+		var env signalingserver.Envelope
+		if err := json.Unmarshal([]byte(payload), &env); err != nil {
+			b.config.Logger.Warn("Dropping malformed envelope", "node", node, "error", err)
+			continue
+		}
+		// broadcasts reach their publisher too
+		if env.From == node {
+			continue
+		}
+		handle(env)
+	}
+}
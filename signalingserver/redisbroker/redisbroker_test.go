@@ -0,0 +1,296 @@
+package redisbroker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/AbdelrahmanWM/signalingserver/utils"
+)
+
+// How long a test waits for Redis or for an envelope
+const testTimeout = 5 * time.Second
+
+// redisAddr returns the address of the Redis server in REDIS_ADDR, or starts a redis-server
+// for the test. The test is skipped when neither is available.
+func redisAddr(t *testing.T) string {
+	t.Helper()
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			t.Skipf("Redis at REDIS_ADDR %s is unavailable: %v", addr, err)
+		}
+		conn.Close()
+		return addr
+	}
+	path, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("REDIS_ADDR is not set and redis-server is not installed")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	cmd := exec.Command(path, "--bind", "127.0.0.1", "--port", strconv.Itoa(port), "--save", "", "--appendonly", "no", "--dir", t.TempDir())
+	if err := cmd.Start(); err != nil {
+		t.Skipf("starting redis-server: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(testTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("redis-server did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// newTestBrokers returns n brokers of one cluster, with keys no other test uses.
+func newTestBrokers(t *testing.T, n int, nodeTTL time.Duration) []*Broker {
+	t.Helper()
+	addr := redisAddr(t)
+	prefix, _ := utils.RandomID(8)
+	brokers := make([]*Broker, n)
+	for i := range brokers {
+		b := New(Config{Addr: addr, Prefix: "signalingserver-test:" + prefix, NodeTTL: nodeTTL, Timeout: testTimeout})
+		t.Cleanup(func() { b.Close() })
+		brokers[i] = b
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		brokers[0].do(ctx, "DEL", brokers[0].peersKey())
+	})
+	return brokers
+}
+
+// subscribe subscribes node through b until the test ends, and returns the envelopes it
+// receives.
+func subscribe(t *testing.T, b *Broker, node string) <-chan signalingserver.Envelope {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	envelopes := make(chan signalingserver.Envelope, 16)
+	if err := b.Subscribe(ctx, node, func(env signalingserver.Envelope) { envelopes <- env }); err != nil {
+		t.Fatalf("subscribing %s: %v", node, err)
+	}
+	return envelopes
+}
+
+// receiveEnvelope returns the next envelope, or fails the test if none comes in time.
+func receiveEnvelope(t *testing.T, envelopes <-chan signalingserver.Envelope) signalingserver.Envelope {
+	t.Helper()
+	select {
+	case env := <-envelopes:
+		return env
+	case <-time.After(testTimeout):
+		t.Fatal("no envelope received in time")
+		return signalingserver.Envelope{}
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestRegister(t *testing.T) {
+	brokers := newTestBrokers(t, 2, 0)
+	b1, b2 := brokers[0], brokers[1]
+	subscribe(t, b1, "n1")
+	subscribe(t, b2, "n2")
+	ctx := testContext(t)
+
+	if ok, err := b1.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n1"}); !ok || err != nil {
+		t.Fatalf("registering p on n1: %v, %v", ok, err)
+	}
+	if ok, err := b2.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n2"}); ok || err != nil {
+		t.Errorf("registering p on n2 while n1 has it: %v, %v, want a conflict", ok, err)
+	}
+	if ok, err := b1.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n1", Rooms: []string{"r"}}); !ok || err != nil {
+		t.Errorf("updating p on n1: %v, %v", ok, err)
+	}
+	peer, exist, err := b2.Lookup(ctx, "p")
+	if !exist || err != nil || peer.Node != "n1" || len(peer.Rooms) != 1 || peer.Rooms[0] != "r" {
+		t.Errorf("looking up p: %+v, %v, %v, want it on n1 in r", peer, exist, err)
+	}
+
+	// only the node of a peer unregisters it
+	if err := b2.Unregister(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist, err := b2.Lookup(ctx, "p"); !exist || err != nil {
+		t.Errorf("p unregistered by n2: %v, %v", exist, err)
+	}
+	if err := b1.Unregister(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist, err := b2.Lookup(ctx, "p"); exist || err != nil {
+		t.Errorf("p still registered after n1 unregistered it: %v, %v", exist, err)
+	}
+	if ok, err := b2.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n2"}); !ok || err != nil {
+		t.Errorf("registering the freed p on n2: %v, %v", ok, err)
+	}
+	peers, err := b1.Peers(ctx)
+	if err != nil || len(peers) != 1 || peers[0].ID != "p" || peers[0].Node != "n2" {
+		t.Errorf("peers %+v, %v, want p on n2", peers, err)
+	}
+}
+
+func TestNodeExpiry(t *testing.T) {
+	nodeTTL := 300 * time.Millisecond
+	b := newTestBrokers(t, 1, nodeTTL)[0]
+	ctx := testContext(t)
+	// a node that stops refreshing its key, as if its process died
+	if err := b.keepAlive(ctx, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "dead"}); !ok || err != nil {
+		t.Fatalf("registering p: %v, %v", ok, err)
+	}
+	if _, exist, err := b.Lookup(ctx, "p"); !exist || err != nil {
+		t.Fatalf("looking up p of a live node: %v, %v", exist, err)
+	}
+	if ok, err := b.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n2"}); ok || err != nil {
+		t.Errorf("registering p of a live node on n2: %v, %v, want a conflict", ok, err)
+	}
+
+	time.Sleep(2 * nodeTTL)
+	if _, exist, err := b.Lookup(ctx, "p"); exist || err != nil {
+		t.Errorf("looking up p of an expired node: %v, %v, want it gone", exist, err)
+	}
+	if peers, err := b.Peers(ctx); len(peers) != 0 || err != nil {
+		t.Errorf("peers %+v, %v, want none", peers, err)
+	}
+	// listing the peers cleans up the records of expired nodes
+	if reply, err := b.do(ctx, "HEXISTS", b.peersKey(), "p"); reply != int64(0) || err != nil {
+		t.Errorf("record of p of an expired node: %v, %v, want it deleted", reply, err)
+	}
+	if ok, err := b.Register(ctx, signalingserver.ClusterPeer{ID: "p", Node: "n2"}); !ok || err != nil {
+		t.Errorf("registering p of an expired node on n2: %v, %v", ok, err)
+	}
+}
+
+func TestSubscriptionKeepsNodeAlive(t *testing.T) {
+	nodeTTL := 300 * time.Millisecond
+	b := newTestBrokers(t, 1, nodeTTL)[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := b.Subscribe(ctx, "n1", func(signalingserver.Envelope) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Subscribe(ctx, "n1", func(signalingserver.Envelope) {}); !errors.Is(err, signalingserver.ErrAlreadySubscribed) {
+		t.Errorf("subscribing n1 twice: %v, want ErrAlreadySubscribed", err)
+	}
+	if ok, err := b.Register(testContext(t), signalingserver.ClusterPeer{ID: "p", Node: "n1"}); !ok || err != nil {
+		t.Fatalf("registering p: %v, %v", ok, err)
+	}
+	time.Sleep(3 * nodeTTL)
+	if _, exist, err := b.Lookup(testContext(t), "p"); !exist || err != nil {
+		t.Errorf("looking up p of a subscribed node: %v, %v", exist, err)
+	}
+
+	cancel()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		_, exist, err := b.Lookup(testContext(t), "p")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exist {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("p still listed after its node unsubscribed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	brokers := newTestBrokers(t, 2, 0)
+	b1, b2 := brokers[0], brokers[1]
+	envelopes1 := subscribe(t, b1, "n1")
+	envelopes2 := subscribe(t, b2, "n2")
+	ctx := testContext(t)
+
+	broadcast := signalingserver.Envelope{
+		Type:    signalingserver.EnvelopeBroadcast,
+		From:    "n1",
+		Peer:    "a",
+		Rooms:   []string{"r"},
+		Message: message.Message{Kind: message.TextMessage, Reach: message.Room, Room: "r", Content: []byte(`{"message":"hi"}`)},
+	}
+	if err := b1.Publish(ctx, broadcast); err != nil {
+		t.Fatal(err)
+	}
+	env := receiveEnvelope(t, envelopes2)
+	if env.Type != signalingserver.EnvelopeBroadcast || env.From != "n1" || env.Peer != "a" || len(env.Rooms) != 1 || env.Message.Room != "r" || string(env.Message.Content) != `{"message":"hi"}` {
+		t.Errorf("n2 got %+v, want the broadcast of n1", env)
+	}
+
+	relay := signalingserver.Envelope{Type: signalingserver.EnvelopeRelay, From: "n2", To: "n1", Peer: "b", Message: message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: "a"}}
+	if err := b2.Publish(ctx, relay); err != nil {
+		t.Fatal(err)
+	}
+	if env := receiveEnvelope(t, envelopes1); env.Type != signalingserver.EnvelopeRelay || env.From != "n2" || env.Message.PeerID != "a" {
+		t.Errorf("n1 got %+v, want the relay of n2", env)
+	}
+	// publishers do not get their own broadcasts, and relays only reach their node
+	select {
+	case env := <-envelopes1:
+		t.Errorf("n1 got unexpected %+v", env)
+	case env := <-envelopes2:
+		t.Errorf("n2 got unexpected %+v", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	brokers := newTestBrokers(t, 2, 0)
+	b1, b2 := brokers[0], brokers[1]
+	subscribe(t, b1, "n1")
+	envelopes2 := subscribe(t, b2, "n2")
+	ctx := testContext(t)
+
+	if _, err := b1.do(ctx, "CLIENT", "KILL", "TYPE", "pubsub"); isError(err) {
+		t.Skipf("the Redis server does not let the test kill connections: %v", err)
+	} else if err != nil {
+		t.Fatalf("killing the subscriber connections: %v", err)
+	}
+	// envelopes published while the node reconnects are lost, so publish until one arrives
+	env := signalingserver.Envelope{Type: signalingserver.EnvelopeBroadcast, From: "n1", Message: message.Message{Kind: message.TextMessage}}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if err := b1.Publish(ctx, env); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-envelopes2:
+			return
+		case <-ticker.C:
+		case <-ctx.Done():
+			t.Fatal("n2 did not subscribe again")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package redisbroker
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply of the Redis server. The connection stays usable after one.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// conn is a connection to Redis speaking RESP2. It is not safe for concurrent use.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// dial connects to the Redis server of config, authenticates and selects its database.
+func dial(ctx context.Context, config *Config) (*conn, error) {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	var netConn net.Conn
+	var err error
+	if config.TLSConfig != nil {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: config.TLSConfig}).DialContext(ctx, "tcp", config.Addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", config.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+	c.SetDeadline(time.Now().Add(config.Timeout))
+	if config.Password != "" {
+		args := []string{"AUTH", config.Password}
+		if config.Username != "" {
+			args = []string{"AUTH", config.Username, config.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	if config.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(config.DB)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("selecting database %d: %w", config.DB, err)
+		}
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// do sends a command and reads its reply.
+func (c *conn) do(args ...string) (any, error) {
+	if err := c.writeCommand(args...); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// writeCommand sends a command as an array of bulk strings.
+func (c *conn) writeCommand(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.w.Flush()
+}
+
+// readReply reads a reply: a string for simple and bulk strings, an int64 for integers,
+// nil for null replies, a []any for arrays, and an Error for error replies. Errors inside
+// an array are items of type Error.
+func (c *conn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		items := make([]any, size)
+		for i := range items {
+			// an error inside an array does not end the reply
+			items[i], err = c.readReply()
+			if isError(err) {
+				items[i] = err
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
+
+// isError reports whether err is an error reply rather than a failure of the connection.
+func isError(err error) bool {
+	var redisErr Error
+	return errors.As(err, &redisErr)
+}
@@ -0,0 +1,91 @@
+package redisbroker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// replyConn returns a conn reading replies from data.
+func replyConn(data string) *conn {
+	return &conn{r: bufio.NewReader(strings.NewReader(data))}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		reply any
+		err   error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"integer", ":-42\r\n", int64(-42), nil},
+		{"bulk string", "$7\r\nhi\r\nyou\r\n", "hi\r\nyou", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"null bulk string", "$-1\r\n", nil, nil},
+		{"null array", "*-1\r\n", nil, nil},
+		{"error", "-ERR unknown command\r\n", nil, Error("ERR unknown command")},
+		{"array", "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$4\r\n{\"a\"\r\n", []any{"message", "ch", `{"a"`}, nil},
+		{"nested arrays", "*3\r\n*2\r\n:1\r\n$-1\r\n*0\r\n+PONG\r\n", []any{[]any{int64(1), nil}, []any{}, "PONG"}, nil},
+		{"error in array", "*2\r\n-ERR no script\r\n:1\r\n", []any{Error("ERR no script"), int64(1)}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, err := replyConn(test.data).readReply()
+			if !reflect.DeepEqual(reply, test.reply) || err != test.err {
+				t.Errorf("got %#v, %v, want %#v, %v", reply, err, test.reply, test.err)
+			}
+		})
+	}
+}
+
+func TestReadReplyFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"missing carriage return", "+OK\n"},
+		{"unknown type", "%1\r\n"},
+		{"truncated bulk string", "$5\r\nhel"},
+		{"truncated array", "*2\r\n:1\r\n"},
+		{"bad integer", ":one\r\n"},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, err := replyConn(test.data).readReply()
+			if err == nil || isError(err) {
+				t.Errorf("got %#v, %v, want a failure", reply, err)
+			}
+		})
+	}
+}
+
+func TestReadReplyKeepsReading(t *testing.T) {
+	c := replyConn("-ERR first\r\n+second\r\n")
+	if _, err := c.readReply(); !isError(err) {
+		t.Fatalf("got %v, want an error reply", err)
+	}
+	if reply, err := c.readReply(); reply != "second" || err != nil {
+		t.Errorf("got %#v, %v after an error reply, want second", reply, err)
+	}
+	if _, err := c.readReply(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v at the end, want EOF", err)
+	}
+}
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	c := &conn{w: bufio.NewWriter(&buf)}
+	if err := c.writeCommand("SET", "key", "a\r\nb"); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\n"
+	if buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
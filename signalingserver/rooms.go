@@ -0,0 +1,235 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// Maximum length of a room name
+const maxRoomNameLength = 128
+
+// roomRegistry tracks room memberships. A peer can be in any number of rooms.
+type roomRegistry struct {
+	mu          sync.RWMutex
+	rooms       map[string]map[*peer]struct{}
+	memberships map[*peer]map[string]struct{}
+}
+
+func newRoomRegistry() *roomRegistry {
+	return &roomRegistry{
+		rooms:       make(map[string]map[*peer]struct{}),
+		memberships: make(map[*peer]map[string]struct{}),
+	}
+}
+
+// join adds p to room and reports false if it was already a member.
+func (r *roomRegistry) join(room string, p *peer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members, exist := r.rooms[room]
+	if !exist {
+		members = make(map[*peer]struct{})
+		r.rooms[room] = members
+	}
+	if _, isMember := members[p]; isMember {
+		return false
+	}
+	members[p] = struct{}{}
+	if r.memberships[p] == nil {
+		r.memberships[p] = make(map[string]struct{})
+	}
+	r.memberships[p][room] = struct{}{}
+	return true
+}
+
+// leave removes p from room and reports false if it was not a member.
+func (r *roomRegistry) leave(room string, p *peer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leaveLocked(room, p)
+}
+
+func (r *roomRegistry) leaveLocked(room string, p *peer) bool {
+	members := r.rooms[room]
+	if _, isMember := members[p]; !isMember {
+		return false
+	}
+	delete(members, p)
+	if len(members) == 0 {
+		delete(r.rooms, room)
+	}
+	delete(r.memberships[p], room)
+	if len(r.memberships[p]) == 0 {
+		delete(r.memberships, p)
+	}
+	return true
+}
+
+// leaveAll removes p from every room and returns the rooms it left.
+func (r *roomRegistry) leaveAll(p *peer) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var left []string
+	for room := range r.memberships[p] {
+		left = append(left, room)
+	}
+	for _, room := range left {
+		r.leaveLocked(room, p)
+	}
+	return left
+}
+
+func (r *roomRegistry) isMember(room string, p *peer) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, isMember := r.rooms[room][p]
+	return isMember
+}
+
+func (r *roomRegistry) inAnyRoom(p *peer) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.memberships[p]) > 0
+}
+
+func (r *roomRegistry) members(room string) []*peer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	members := make([]*peer, 0, len(r.rooms[room]))
+	for p := range r.rooms[room] {
+		members = append(members, p)
+	}
+	return members
+}
+
+func (r *roomRegistry) roomsOf(p *peer) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rooms := make([]string, 0, len(r.memberships[p]))
+	for room := range r.memberships[p] {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (r *roomRegistry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.rooms))
+	for room := range r.rooms {
+		names = append(names, room)
+	}
+	return names
+}
+
+// GetRooms returns the names of all rooms that currently have members, on every node of
+// the cluster.
+func (s *SignalingServer) GetRooms() []string {
+	names := s.rooms.names()
+	remote, err := s.remotePeers()
+	if err != nil {
+		s.logger.Error("Failed to list the peers of the cluster", "error", err)
+	}
+	for _, peer := range remote {
+		for _, room := range peer.Rooms {
+			if !slices.Contains(names, room) {
+				names = append(names, room)
+			}
+		}
+	}
+	return names
+}
+
+// GetRoomPeerIDs returns the IDs of the peers in room, on every node of the cluster.
+func (s *SignalingServer) GetRoomPeerIDs(room string) []string {
+	members := s.rooms.members(room)
+	ids := make([]string, 0, len(members))
+	for _, p := range members {
+		ids = append(ids, p.id)
+	}
+	remote, err := s.remotePeerIDs([]string{room})
+	if err != nil {
+		s.logger.Error("Failed to list the peers of the cluster", "error", err)
+	}
+	return append(ids, remote...)
+}
+
+// visiblePeers returns the peers p can discover and broadcast to: the members of its rooms,
+// or, for a peer that has not joined any room, the other peers outside of rooms.
+func (s *SignalingServer) visiblePeers(p *peer) []*peer {
+	rooms := s.rooms.roomsOf(p)
+	if len(rooms) == 0 {
+		return s.peersOutsideRooms()
+	}
+	return s.roomMembers(rooms)
+}
+
+// roomMembers returns the peers that are members of any of rooms.
+func (s *SignalingServer) roomMembers(rooms []string) []*peer {
+	seen := make(map[*peer]struct{})
+	var peers []*peer
+	for _, room := range rooms {
+		for _, member := range s.rooms.members(room) {
+			if _, ok := seen[member]; !ok {
+				seen[member] = struct{}{}
+				peers = append(peers, member)
+			}
+		}
+	}
+	return peers
+}
+
+// peersOutsideRooms returns the peers that have not joined any room.
+func (s *SignalingServer) peersOutsideRooms() []*peer {
+	var peers []*peer
+	for _, p := range s.peers.all() {
+		if !s.rooms.inAnyRoom(p) {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+func validRoomName(room string) bool {
+	return room != "" && len(room) <= maxRoomNameLength
+}
+
+// notifyRoom sends a JoinRoom/LeaveRoom notification about p to the other members of room,
+// on every node of the cluster.
+func (s *SignalingServer) notifyRoom(p *peer, kind message.MessageType, room string) {
+	var notificationContent any = message.JoinRoomContent{Room: room, PeerID: p.id}
+	if kind == message.LeaveRoom {
+		notificationContent = message.LeaveRoomContent{Room: room, PeerID: p.id}
+	}
+	content, err := json.Marshal(notificationContent)
+	if err != nil {
+		p.logger.Error("Failed to marshal room notification", "room", room, "error", err)
+		return
+	}
+	notification := message.Message{
+		Kind:    kind,
+		Reach:   message.Room,
+		Sender:  "server",
+		Room:    room,
+		Content: content,
+	}
+	for _, member := range s.rooms.members(room) {
+		if member == p {
+			continue
+		}
+		if err := s.send(member, notification); err != nil {
+			member.logger.Warn("Failed to send room notification", "room", room, "error", err)
+		}
+	}
+	s.broadcastCluster("", []string{room}, notification)
+}
+
+// leaveAllRooms removes p from all of its rooms and notifies the remaining members.
+func (s *SignalingServer) leaveAllRooms(p *peer) {
+	for _, room := range s.rooms.leaveAll(p) {
+		s.notifyRoom(p, message.LeaveRoom, room)
+	}
+}
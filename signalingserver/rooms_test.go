@@ -0,0 +1,91 @@
+package signalingserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// joinRoom makes c join room and waits for the server to confirm it.
+func (c *testClient) joinRoom(room string) {
+	c.t.Helper()
+	c.sendContent(message.JoinRoom, message.Self, message.JoinRoomContent{Room: room})
+	var content message.JoinRoomContent
+	unmarshalContent(c.t, c.receiveKind(message.JoinRoom), &content)
+	if content.Room != room {
+		c.t.Fatalf("joined room %q, want %q", content.Room, room)
+	}
+}
+
+// peerIDs asks the server the IDs of the peers c can discover in room, or in its own rooms.
+func (c *testClient) peerIDs(room string) []string {
+	c.t.Helper()
+	c.send(message.Message{Kind: message.GetAllPeerIDs, Reach: message.Self, Room: room})
+	var content message.GetAllPeerIDsContent
+	unmarshalContent(c.t, c.receiveKind(message.GetAllPeerIDs), &content)
+	return content.PeersIDs
+}
+
+// receiveError returns the content of the next Error message, skipping the other kinds.
+func (c *testClient) receiveError() message.ErrorContent {
+	c.t.Helper()
+	var content message.ErrorContent
+	unmarshalContent(c.t, c.receiveKind(message.Error), &content)
+	return content
+}
+
+func TestRooms(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	a := dialFeatures(t, url, message.FeatureRooms, message.FeatureErrors)
+	b := dialFeatures(t, url, message.FeatureRooms, message.FeatureErrors)
+	c := dialFeatures(t, url, message.FeatureRooms, message.FeatureErrors)
+	lobby := dialFeatures(t, url, message.FeatureRooms, message.FeatureErrors)
+	idA, idB := a.identify(), b.identify()
+
+	a.joinRoom("r1")
+	b.joinRoom("r1")
+	var joined message.JoinRoomContent
+	unmarshalContent(t, a.receive(), &joined)
+	if joined.Room != "r1" || joined.PeerID != idB {
+		t.Fatalf("a was told %+v, want b joining r1", joined)
+	}
+	c.joinRoom("r2")
+
+	if ids := a.peerIDs(""); len(ids) != 1 || ids[0] != idB {
+		t.Errorf("a discovers %v, want only b", ids)
+	}
+	if ids := lobby.peerIDs(""); len(ids) != 0 {
+		t.Errorf("peer outside rooms discovers %v, want none", ids)
+	}
+
+	a.send(message.Message{Kind: message.TextMessage, Reach: message.Room, Room: "r1", Content: []byte(`{"message":"hi"}`)})
+	msg := b.receive()
+	if msg.Kind != message.TextMessage || msg.Reach != message.Room || msg.Room != "r1" || msg.Sender != idA {
+		t.Errorf("b got %+v, want the room message of a", msg)
+	}
+	a.sendContent(message.TextMessage, message.AllPeers, message.TextMessageContent{Message: "all"})
+	if msg := b.receive(); msg.Kind != message.TextMessage || msg.Reach == message.Room {
+		t.Errorf("b got %+v, want the broadcast of a", msg)
+	}
+	c.expectNothing(100 * time.Millisecond)
+	lobby.expectNothing(100 * time.Millisecond)
+
+	c.send(message.Message{Kind: message.TextMessage, Reach: message.Room, Room: "r1", Content: []byte(`{}`)})
+	if content := c.receiveError(); content.Code != message.ErrorNotInRoom {
+		t.Errorf("c got error %v, want %v", content.Code, message.ErrorNotInRoom)
+	}
+	b.expectNothing(100 * time.Millisecond)
+
+	b.sendContent(message.LeaveRoom, message.Self, message.LeaveRoomContent{Room: "r1"})
+	b.receiveKind(message.LeaveRoom)
+	var left message.LeaveRoomContent
+	unmarshalContent(t, a.receiveKind(message.LeaveRoom), &left)
+	if left.Room != "r1" || left.PeerID != idB {
+		t.Errorf("a was told %+v, want b leaving r1", left)
+	}
+	if ids := s.GetRoomPeerIDs("r1"); len(ids) != 1 || ids[0] != idA {
+		t.Errorf("r1 has %v, want only a", ids)
+	}
+}
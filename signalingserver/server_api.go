@@ -0,0 +1,97 @@
+package signalingserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// ErrPeerNotFound is returned when no connected peer has the given ID.
+var ErrPeerNotFound = errors.New("peer not found")
+
+// Longest text a websocket close frame can carry
+const maxCloseReasonLength = 123
+
+// Peer returns the connected peer peerID.
+func (s *SignalingServer) Peer(peerID string) (*Peer, bool) {
+	p, exist := s.peers.get(peerID)
+	if !exist {
+		return nil, false
+	}
+	return s.handle(p), true
+}
+
+// Peers returns the connected peers.
+func (s *SignalingServer) Peers() []*Peer {
+	peers := s.peers.all()
+	handles := make([]*Peer, len(peers))
+	for i, p := range peers {
+		handles[i] = s.handle(p)
+	}
+	return handles
+}
+
+// SendTo sends msg to the peer peerID, as the server unless msg has a sender. Like
+// messages between peers, it is held for a peer that is offline when store-and-forward
+// is enabled, and dropped for clients that do not understand its kind.
+// It is safe to call from any goroutine.
+func (s *SignalingServer) SendTo(peerID string, msg message.Message) error {
+	if msg.Sender == "" {
+		msg.Sender = "server"
+	}
+	p, exist := s.peers.get(peerID)
+	if !exist {
+		queued, err := s.queue(peerID, msg)
+		if err != nil {
+			return fmt.Errorf("queuing message for offline peer %s: %w", peerID, err)
+		}
+		if !queued {
+			return ErrPeerNotFound
+		}
+		return nil
+	}
+	if err := s.send(p, msg); err != nil {
+		return fmt.Errorf("sending message to peer %s: %w", peerID, err)
+	}
+	return nil
+}
+
+// Broadcast sends msg to every connected peer filter accepts, or to all of them if filter
+// is nil, as the server unless msg has a sender. It returns how many peers it was sent to.
+// It is safe to call from any goroutine.
+func (s *SignalingServer) Broadcast(msg message.Message, filter func(p *Peer) bool) int {
+	if msg.Sender == "" {
+		msg.Sender = "server"
+	}
+	sent := 0
+	for _, p := range s.peers.all() {
+		if filter != nil && !filter(s.handle(p)) {
+			continue
+		}
+		if err := s.send(p, msg); err != nil {
+			p.logger.Warn("Failed to broadcast message", "kind", msg.Kind, "error", err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// Disconnect closes the connection of the peer peerID with reason as the close text, after
+// the messages already queued for it. Other peers are told it was kicked, and it cannot
+// resume its session. It is safe to call from any goroutine.
+func (s *SignalingServer) Disconnect(peerID string, reason string) error {
+	p, exist := s.peers.get(peerID)
+	if !exist {
+		return ErrPeerNotFound
+	}
+	if len(reason) > maxCloseReasonLength {
+		reason = strings.ToValidUTF8(reason[:maxCloseReasonLength], "")
+	}
+	p.logger.Info("Disconnecting peer", "reason", reason)
+	s.kick(p, websocket.CloseNormalClosure, reason)
+	return nil
+}
@@ -0,0 +1,85 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+func TestServerAPI(t *testing.T) {
+	s := NewSignalingServer(10, false, false)
+	url := newTestServer(t, s)
+	a, b := dial(t, url), dial(t, url)
+	idA, idB := a.identify(), b.identify()
+	a.joinRoom("r")
+
+	p, ok := s.Peer(idA)
+	if !ok || p.ID() != idA || p.JoinedAt().IsZero() || len(p.Rooms()) != 1 || p.Rooms()[0] != "r" {
+		t.Errorf("Peer(a) = %+v, %v, want a in room r", p, ok)
+	}
+	if _, ok := s.Peer("nobody"); ok {
+		t.Error("found a peer that does not exist")
+	}
+	if peers := s.Peers(); len(peers) != 2 {
+		t.Errorf("got %d peers, want 2", len(peers))
+	}
+
+	// from many goroutines at once
+	const sends = 50
+	content, _ := json.Marshal(message.TextMessageContent{Title: "billing", Message: "paid"})
+	notice := message.Message{Kind: message.TextMessage, Reach: message.Self, Content: content}
+	var wg sync.WaitGroup
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.SendTo(idA, notice); err != nil {
+				t.Errorf("SendTo(a): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < sends; i++ {
+		if msg := a.receiveKind(message.TextMessage); msg.Sender != "server" || string(msg.Content) != string(content) {
+			t.Fatalf("a got %+v, want the notice from the server", msg)
+		}
+	}
+	if err := s.SendTo("nobody", notice); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("SendTo(nobody) = %v, want ErrPeerNotFound", err)
+	}
+
+	if sent := s.Broadcast(notice, func(p *Peer) bool { return p.ID() != idA }); sent != 1 {
+		t.Errorf("filtered broadcast sent to %d peers, want 1", sent)
+	}
+	b.receiveKind(message.TextMessage)
+	if sent := s.Broadcast(message.Message{Kind: message.TextMessage, Sender: "billing", Content: content}, nil); sent != 2 {
+		t.Errorf("broadcast sent to %d peers, want 2", sent)
+	}
+	if msg := a.receiveKind(message.TextMessage); msg.Sender != "billing" {
+		t.Errorf("broadcast sent by %q, want its own sender", msg.Sender)
+	}
+	b.receiveKind(message.TextMessage)
+
+	reason := "meeting over " + strings.Repeat("é", 100)
+	if err := s.Disconnect(idB, reason); err != nil {
+		t.Fatal(err)
+	}
+	err := b.closed()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+		t.Fatalf("b closed with %v, want a normal closure", err)
+	}
+	if len(closeErr.Text) > maxCloseReasonLength || !utf8.ValidString(closeErr.Text) || !strings.HasPrefix(closeErr.Text, "meeting over é") {
+		t.Errorf("close reason %q is not the reason truncated to valid UTF-8", closeErr.Text)
+	}
+	waitFor(t, "b to be unregistered", func() bool { return s.peers.len() == 1 })
+	if err := s.Disconnect(idB, "again"); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("disconnecting b twice: got %v, want ErrPeerNotFound", err)
+	}
+}
@@ -0,0 +1,168 @@
+package signalingserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// Query parameter a reconnecting client passes its resume token in
+const ResumeTokenQueryParam = "resume_token"
+
+// sessionRegistry maps resume tokens to the peers they resume.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*peer
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[string]*peer)}
+}
+
+func (r *sessionRegistry) add(token string, p *peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[token] = p
+}
+
+func (r *sessionRegistry) get(token string) (*peer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, exist := r.sessions[token]
+	return p, exist
+}
+
+func (r *sessionRegistry) remove(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, token)
+}
+
+func generateResumeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// WithSessionResumption lets a peer that lost its connection reclaim its ID by reconnecting
+// within grace with the resume token from its IdentifySelf response, passed in the
+// ResumeTokenQueryParam query parameter. Messages for the peer are held until it is back,
+// and other peers are only told it disconnected once the grace window has passed.
+func WithSessionResumption(grace time.Duration) Option {
+	return func(s *SignalingServer) {
+		s.resumeGrace = grace
+	}
+}
+
+// token returns the current resume token of p.
+func (p *peer) token() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumeToken
+}
+
+// canResume reports whether p may be resumed after its connection ended with err.
+// Peers that left on purpose or were kicked or evicted by the server cannot.
+func (s *SignalingServer) canResume(p *peer, err error) bool {
+	if s.resumeGrace <= 0 || p.kicked.Load() || p.quit.Load() || p.disconnectNotified.Load() {
+		return false
+	}
+	if errors.Is(err, errPongTimeout) {
+		return true
+	}
+	return disconnectReason(p, err) == message.DisconnectError && !errors.Is(err, errIdleTimeout)
+}
+
+// detachPeer keeps p registered without a connection for the grace window. It reports
+// false if c is no longer the current connection of p.
+func (s *SignalingServer) detachPeer(p *peer, c *peerConn, reason message.DisconnectReason) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != c || p.left {
+		return false
+	}
+	p.conn = nil
+	p.graceTimer = time.AfterFunc(s.resumeGrace, func() {
+		s.expireSession(p, reason)
+	})
+	p.logger.Info("Peer detached, holding its session", "grace", s.resumeGrace)
+	return true
+}
+
+// expireSession unregisters a detached peer whose grace window has passed.
+func (s *SignalingServer) expireSession(p *peer, reason message.DisconnectReason) {
+	p.mu.Lock()
+	if p.conn != nil || p.left {
+		p.mu.Unlock()
+		return
+	}
+	p.left = true
+	p.mu.Unlock()
+	p.logger.Info("Session expired")
+	s.unregisterPeer(p, reason)
+}
+
+// resumePeer attaches c to the peer whose resume token r carries, and returns nil
+// if r does not resume a session.
+func (s *SignalingServer) resumePeer(r *http.Request, identity *auth.Identity, c *peerConn) *peer {
+	if s.resumeGrace <= 0 {
+		return nil
+	}
+	token := r.URL.Query().Get(ResumeTokenQueryParam)
+	if token == "" {
+		return nil
+	}
+	p, exist := s.sessions.get(token)
+	if !exist {
+		s.logger.Info("Unknown or expired resume token", "remote_addr", r.RemoteAddr)
+		return nil
+	}
+	if p.identity != nil && (identity == nil || identity.Subject != p.identity.Subject) {
+		p.logger.Warn("Resume token presented by another identity", "remote_addr", r.RemoteAddr)
+		return nil
+	}
+	newToken, err := generateResumeToken()
+	if err != nil {
+		p.logger.Error("Failed to generate resume token", "error", err)
+		return nil
+	}
+
+	p.mu.Lock()
+	if p.left || p.resumeToken != token {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.graceTimer != nil {
+		p.graceTimer.Stop()
+		p.graceTimer = nil
+	}
+	previous := p.conn
+	p.conn = c
+	p.resumeToken = newToken
+	p.mu.Unlock()
+
+	s.sessions.remove(token)
+	s.sessions.add(newToken, p)
+	// a client can reconnect before the server notices its previous connection is dead
+	if previous != nil {
+		previous.abort()
+		<-previous.pumpDone
+	}
+	go p.writePump(c)
+
+	// held messages go out first, then the new token
+	content, err := json.Marshal(message.IdentifySelfContent{ID: p.id, ResumeToken: newToken})
+	if err == nil {
+		s.send(p, message.Message{Kind: message.IdentifySelf, Reach: message.Self, Sender: "server", PeerID: p.id, Content: content})
+	}
+	return p
+}
@@ -0,0 +1,106 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// resumeToken asks the server the ID and resume token of c.
+func (c *testClient) resumeToken() (string, string) {
+	c.t.Helper()
+	c.send(message.Message{Kind: message.IdentifySelf, Reach: message.Self})
+	var content message.IdentifySelfContent
+	unmarshalContent(c.t, c.receiveKind(message.IdentifySelf), &content)
+	if content.ResumeToken == "" {
+		c.t.Fatal("IdentifySelf has no resume token")
+	}
+	return content.ID, content.ResumeToken
+}
+
+func TestSessionResumption(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithSessionResumption(time.Second))
+	url := newTestServer(t, s)
+	observer := dial(t, url)
+	a := dial(t, url)
+	observer.receiveKind(message.PeerJoined)
+	id, token := a.resumeToken()
+
+	a.UnderlyingConn().Close()
+	waitFor(t, "the connection to be detached", func() bool {
+		p, ok := s.peers.get(id)
+		return ok && p.current() == nil
+	})
+	for i := 0; i < 3; i++ {
+		content, _ := json.Marshal(message.TextMessageContent{Message: fmt.Sprint(i)})
+		observer.send(message.Message{ID: fmt.Sprint("m", i), Kind: message.TextMessage, Reach: message.OnePeer, PeerID: id, Content: content})
+		var ack message.AckContent
+		unmarshalContent(t, observer.receiveKind(message.Ack), &ack)
+		if ack.Status != message.AckQueued {
+			t.Errorf("message %d acknowledged as %v, want queued", i, ack.Status)
+		}
+	}
+
+	resumed := dialLegacy(t, url+"?"+ResumeTokenQueryParam+"="+token)
+	for i := 0; i < 3; i++ {
+		var content message.TextMessageContent
+		unmarshalContent(t, resumed.receiveKind(message.TextMessage), &content)
+		if content.Message != fmt.Sprint(i) {
+			t.Errorf("held message %d is %q", i, content.Message)
+		}
+	}
+	var identity message.IdentifySelfContent
+	unmarshalContent(t, resumed.receiveKind(message.IdentifySelf), &identity)
+	if identity.ID != id {
+		t.Errorf("resumed with ID %s, want %s", identity.ID, id)
+	}
+	if identity.ResumeToken == "" || identity.ResumeToken == token {
+		t.Errorf("resumed session got token %q, want a new one", identity.ResumeToken)
+	}
+	observer.expectNothing(200 * time.Millisecond)
+
+	// a token works once
+	stale := dialLegacy(t, url+"?"+ResumeTokenQueryParam+"="+token)
+	if staleID := stale.identify(); staleID == id {
+		t.Error("stale token resumed the session")
+	}
+	observer.receiveKind(message.PeerJoined)
+
+	// peers are told once the grace window has passed
+	resumed.UnderlyingConn().Close()
+	start := time.Now()
+	notification := observer.receiveDisconnection()
+	if notification.DisconnectedPeerID != id || notification.Reason != message.DisconnectError {
+		t.Errorf("got %+v, want the disconnection of %s", notification, id)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("peers told after %v, within the grace window", elapsed)
+	}
+	if _, exist := s.Peer(id); exist {
+		t.Error("expired session is still registered")
+	}
+	expired := dialLegacy(t, url+"?"+ResumeTokenQueryParam+"="+identity.ResumeToken)
+	if expiredID := expired.identify(); expiredID == id {
+		t.Error("expired session was resumed")
+	}
+}
+
+func TestSessionNotResumedAfterLeaving(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithSessionResumption(time.Minute))
+	url := newTestServer(t, s)
+	a := dialLegacy(t, url)
+	id, token := a.resumeToken()
+	a.sendContent(message.Disconnect, message.Self, message.DisconnectContent{})
+	a.closed()
+	waitFor(t, "the peer to be unregistered", func() bool {
+		_, exist := s.Peer(id)
+		return !exist
+	})
+	again := dialLegacy(t, url+"?"+ResumeTokenQueryParam+"="+token)
+	if againID := again.identify(); againID == id {
+		t.Error("session of a peer that sent Disconnect was resumed")
+	}
+}
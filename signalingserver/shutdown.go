@@ -0,0 +1,91 @@
+package signalingserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+// ErrServerShutdown is returned for work the server refuses because it is shutting down.
+var ErrServerShutdown = errors.New("server is shutting down")
+
+// ShutdownConfig sets what Shutdown tells clients about when to come back.
+type ShutdownConfig struct {
+	// How long clients should wait before reconnecting, 0 for no hint
+	ReconnectAfter time.Duration
+
+	// Random delay of up to ReconnectJitter added to the hint of each peer, so clients do
+	// not all reconnect at once
+	ReconnectJitter time.Duration
+
+	// Text sent with ServerShutdown
+	Message string
+}
+
+// WithShutdownConfig sets the reconnect hint Shutdown sends to peers.
+func WithShutdownConfig(config ShutdownConfig) Option {
+	return func(s *SignalingServer) {
+		s.shutdown = config
+	}
+}
+
+// Shutdown stops accepting connections, sends every peer a ServerShutdown message, as a
+// TextMessage titled "shutdown" to clients without the shutdown feature, and closes its
+// connection with CloseGoingAway once what is queued for it is written. It
+// waits for the connections to close until ctx expires, then drops the remaining ones
+// and returns ctx.Err(). A server in a cluster leaves it on return. Shutdown does not
+// stop the http.Server the handlers are mounted on, call its Shutdown after this one.
+func (s *SignalingServer) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	defer s.leaveCluster()
+	peers := s.peers.all()
+	s.logger.Info("Shutting down", "peers", len(peers))
+	var conns []*peerConn
+	for _, p := range peers {
+		// peers are not told about each other going away, and sessions cannot be resumed
+		p.markDisconnectNotified()
+		if err := s.send(p, s.shutdownMessage(p)); err != nil {
+			p.logger.Warn("Failed to send shutdown message", "error", err)
+		}
+		if c := p.current(); c != nil {
+			conns = append(conns, c)
+			c.closeWith(websocket.CloseGoingAway, "server shutting down")
+		} else {
+			s.expireSession(p, message.DisconnectGraceful)
+		}
+	}
+	for _, p := range peers {
+		select {
+		case <-p.gone:
+		case <-ctx.Done():
+			s.logger.Warn("Shutdown timed out, dropping the remaining connections", "error", ctx.Err())
+			for _, c := range conns {
+				c.ws.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	for _, c := range conns {
+		select {
+		case <-c.pumpDone:
+		case <-ctx.Done():
+			c.ws.Close()
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *SignalingServer) shutdownMessage(p *peer) message.Message {
+	reconnectAfter := s.shutdown.ReconnectAfter
+	if s.shutdown.ReconnectJitter > 0 {
+		reconnectAfter += rand.N(s.shutdown.ReconnectJitter)
+	}
+	// marshaling a struct of a string and an int cannot fail
+	content, _ := json.Marshal(message.ServerShutdownContent{Message: s.shutdown.Message, ReconnectAfterMs: reconnectAfter.Milliseconds()})
+	return message.Message{Kind: message.ServerShutdown, Reach: message.Self, Sender: "server", PeerID: p.id, Content: content}
+}
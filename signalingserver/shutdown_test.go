@@ -0,0 +1,92 @@
+package signalingserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdown(t *testing.T) {
+	var disconnects atomic.Int32
+	s := NewSignalingServer(10, true, false,
+		WithShutdownConfig(ShutdownConfig{ReconnectAfter: time.Second, ReconnectJitter: time.Second, Message: "deploy"}),
+		WithInterceptors(InterceptorFuncs{Disconnect: func(p *Peer, reason message.DisconnectReason) { disconnects.Add(1) }}))
+	url := newTestServer(t, s)
+	a, legacy := dial(t, url), dialLegacy(t, url)
+	a.identify()
+	legacy.identify()
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), receiveTimeout)
+		defer cancel()
+		done <- s.Shutdown(ctx)
+	}()
+
+	var content message.ServerShutdownContent
+	unmarshalContent(t, a.receive(), &content)
+	if content.Message != "deploy" || content.ReconnectAfterMs < 1000 || content.ReconnectAfterMs >= 2000 {
+		t.Errorf("got %+v, want the message and a reconnect hint within the jitter", content)
+	}
+	// peers are not told about each other going away
+	for _, c := range []*testClient{a, legacy} {
+		if err := c.closed(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("connection closed with %v, want going away", err)
+		}
+	}
+	// clients predating ServerShutdown get it as a text message
+	var text message.TextMessageContent
+	unmarshalContent(t, legacy.receiveKind(message.TextMessage), &text)
+	if text.Title != "shutdown" || !strings.HasPrefix(text.Message, "deploy (reconnect after 1") {
+		t.Errorf("legacy client got %+v, want the message and the reconnect hint", text)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+	if n := s.peers.len(); n != 0 {
+		t.Errorf("%d peers left after shutdown", n)
+	}
+	if n := disconnects.Load(); n != 2 {
+		t.Errorf("interceptors saw %d disconnections, want 2", n)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("connecting after shutdown: got %v, want 503", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	// a client that never reads, with more queued for it than the socket buffers hold,
+	// so writing to it blocks
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "the peer to be registered", func() bool { return s.peers.len() == 1 })
+	id := s.GetAllPeerIDs()[0]
+	content, _ := json.Marshal(message.TextMessageContent{Message: strings.Repeat("x", 1<<20)})
+	for i := 0; i < 64; i++ {
+		if err := s.SendTo(id, message.Message{Kind: message.TextMessage, Reach: message.Self, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown returned %v, want the deadline error", err)
+	}
+	waitFor(t, "the dropped peer to be unregistered", func() bool { return s.peers.len() == 0 })
+}
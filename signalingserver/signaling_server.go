@@ -1,25 +1,41 @@
 package signalingserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"slices"
+	"sync/atomic"
+	"time"
 
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
 	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
-	"github.com/AbdelrahmanWM/signalingserver/utils"
 	"github.com/gorilla/websocket"
 )
 
 type SignalingServer struct {
-	peers map[string]*websocket.Conn
+	peers *peerRegistry
 
-	// Length of each peer ID
-	idLength int
+	rooms *roomRegistry
+
+	presence *presence
+
+	idGenerator IDGenerator
+
+	// Decides which client-requested peer IDs are granted, nil to ignore them
+	preferredIDPolicy PreferredIDPolicy
 
 	webSocketUpgrader websocket.Upgrader
 
+	// Codecs clients can pick as subprotocol, in order of preference
+	codecs []message.Codec
+
+	// Default origin policy of the websocket handler
+	originPolicy OriginPolicy
+
 	// This flag controls whether the server should identify a message being sent to the same peer
 	// (i.e., the message is being sent to the "self" peer) by appending a label like (self) or (To self) to the message.
 	identifyMessageSender bool
@@ -27,196 +43,753 @@ type SignalingServer struct {
 	// This flag controls whether the server should include the requesting peer ID
 	// to the 'GetAllPeerIDs' message.
 	addSelfToGetPeerIDs bool
+
+	// Capacity of each peer's outbound message queue
+	sendQueueSize int
+
+	// Authenticates requests before they are upgraded, nil to accept everyone
+	authenticator auth.Authenticator
+
+	keepalive KeepaliveConfig
+
+	sessions *sessionRegistry
+
+	// How long a disconnected peer can take to resume its session, 0 disables resumption
+	resumeGrace time.Duration
+
+	// Holds messages for offline peers, nil when store-and-forward is disabled
+	storeAndForward *storeAndForward
+
+	// Limits applied to the messages of each peer, nil when rate limiting is disabled
+	rateLimits        *RateLimitConfig
+	rateLimitCounters rateLimitCounters
+
+	// Server-side handlers of application-defined kinds
+	kindHandlers map[message.MessageType]KindHandler
+
+	// Called on the connections, messages and disconnections of every peer, in order
+	interceptors []Interceptor
+
+	startedAt     time.Time
+	messageTotals messageTotals
+	metrics       *metrics
+
+	logger *slog.Logger
+	// Whether message contents are logged without redaction
+	debugLogging bool
+
+	shutdown     ShutdownConfig
+	shuttingDown atomic.Bool
+
+	// Other servers the peers are spread across, nil when the server runs alone
+	cluster *cluster
 }
 
-func NewSignalingServer(id_length int, identifyMessageSender, addSelfToGetAllPeerIDs bool) *SignalingServer {
-	peers := make(map[string]*websocket.Conn)
-	var webSocketUpgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // all origins for now
-		},
+func NewSignalingServer(id_length int, identifyMessageSender, addSelfToGetAllPeerIDs bool, options ...Option) *SignalingServer {
+	s := &SignalingServer{
+		peers:                 newPeerRegistry(),
+		rooms:                 newRoomRegistry(),
+		presence:              newPresence(),
+		sessions:              newSessionRegistry(),
+		idGenerator:           RandomIDGenerator(id_length),
+		originPolicy:          AllowSameOrigin(),
+		identifyMessageSender: identifyMessageSender,
+		addSelfToGetPeerIDs:   addSelfToGetAllPeerIDs,
+		sendQueueSize:         defaultSendQueueSize,
+		keepalive:             DefaultKeepaliveConfig(),
+		codecs:                message.Codecs,
+		startedAt:             time.Now(),
+		metrics:               newMetrics(),
+		logger:                slog.Default(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.webSocketUpgrader.CheckOrigin = s.checkOrigin(s.originPolicy)
+	for _, codec := range s.codecs {
+		if !slices.Contains(s.webSocketUpgrader.Subprotocols, codec.Name()) {
+			s.webSocketUpgrader.Subprotocols = append(s.webSocketUpgrader.Subprotocols, codec.Name())
+		}
 	}
-	return &SignalingServer{peers, id_length, webSocketUpgrader, identifyMessageSender, addSelfToGetAllPeerIDs}
+	if s.cluster != nil {
+		s.joinCluster()
+	}
+	return s
 }
 
-func (s *SignalingServer) generateRandomID() string {
-	return utils.GenerateRandomID(s.idLength)
-}
-func (s *SignalingServer) upgradeToWebSocketConn(responseWriter http.ResponseWriter, request *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
-	return s.webSocketUpgrader.Upgrade(responseWriter, request, responseHeader)
+func (s *SignalingServer) upgradeToWebSocketConn(upgrader *websocket.Upgrader, responseWriter http.ResponseWriter, request *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
+	return upgrader.Upgrade(responseWriter, request, responseHeader)
 }
+
+// GetAllPeerIDs returns the IDs of the connected peers, on every node of the cluster
+// when the server is in one.
 func (s *SignalingServer) GetAllPeerIDs() []string {
-	var keys []string
-	for key, _ := range s.peers {
-		keys = append(keys, key)
+	ids := s.peers.ids()
+	remote, err := s.remotePeers()
+	if err != nil {
+		s.logger.Error("Failed to list the peers of the cluster", "error", err)
 	}
-	return keys
+	for _, peer := range remote {
+		ids = append(ids, peer.ID)
+	}
+	return ids
 }
-func (s *SignalingServer) HandleWebSocketConn(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgradeToWebSocketConn(w, r, nil)
+
+// authenticate runs the server's authenticator against r. It writes the rejection response
+// and returns false if the request must not be upgraded.
+func (s *SignalingServer) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Identity, bool) {
+	if s.authenticator == nil {
+		return nil, true
+	}
+	identity, err := s.authenticator.Authenticate(r)
 	if err != nil {
-		log.Println("Failed to upgrade to webSocket connection", err)
-	}
-	connID := s.generateRandomID()
-	s.peers[connID] = conn
-	log.Println("New socket connection: ", connID)
-	defer conn.Close()
-	for {
-		_, p, err := conn.ReadMessage()
+		status := auth.Status(err)
+		s.logger.Info("Rejected connection", "remote_addr", r.RemoteAddr, "error", err)
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, err.Error(), status)
+		return nil, false
+	}
+	return identity, true
+}
+
+// registerPeer adds a new peer for c and starts its write pump. The peer gets preferredID
+// if it is not empty and free, a generated ID otherwise.
+func (s *SignalingServer) registerPeer(c *peerConn, identity *auth.Identity, preferredID string) (*peer, error) {
+	var resumeToken string
+	if s.resumeGrace > 0 {
+		var err error
+		if resumeToken, err = generateResumeToken(); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		id, err := s.nextID(preferredID, attempt)
 		if err != nil {
-			if closeErr, ok := err.(*websocket.CloseError); ok {
-				switch closeErr.Code {
-				case websocket.CloseNormalClosure, websocket.CloseGoingAway:
-					log.Println("Client disconnected gracefully")
-				default:
-					log.Printf("Web socket closed with unexpected code %d: %v\n", closeErr.Code, err)
+			return nil, err
+		}
+		p := newPeer(id, s.sendQueueSize, s.keepalive)
+		p.identity = identity
+		p.preferredID = attempt == 0 && preferredID != ""
+		p.logger = s.logger.With("peer_id", id)
+		p.totals = &s.messageTotals
+		if s.rateLimits != nil {
+			p.limiter = newRateLimiter(s.rateLimits)
+		}
+		p.resumeToken = resumeToken
+		if s.peers.add(p) {
+			// Shutdown misses peers registered after it took its snapshot of them
+			if s.shuttingDown.Load() {
+				s.peers.remove(p)
+				return nil, ErrServerShutdown
+			}
+			claimed, err := s.claimPeer(p)
+			if err != nil {
+				s.peers.remove(p)
+				return nil, fmt.Errorf("registering peer with the cluster: %w", err)
+			}
+			if claimed {
+				if resumeToken != "" {
+					s.sessions.add(resumeToken, p)
 				}
-				delete(s.peers, connID)
-			} else {
-				log.Printf("Error reading message: %v\n", err)
+				p.attach(c)
+				return p, nil
 			}
+			// taken by a peer of another node
+			s.peers.remove(p)
+		}
+		if attempt == 0 && preferredID != "" {
+			s.logger.Info("Preferred peer ID is taken", "peer_id", preferredID)
+		}
+	}
+}
+
+// unregisterPeer removes p from the registry and its rooms, stops its write pump and
+// tells the peers that could see it that it went away, unless they were already told.
+func (s *SignalingServer) unregisterPeer(p *peer, reason message.DisconnectReason) {
+	audience, rooms := s.visiblePeers(p), s.rooms.roomsOf(p)
+	s.peers.remove(p)
+	s.releasePeer(p)
+	s.leaveAllRooms(p)
+	p.leave()
+	if token := p.token(); token != "" {
+		s.sessions.remove(token)
+	}
+	if s.storeAndForward != nil {
+		s.storeAndForward.wentOffline(p)
+	}
+	s.unsubscribePeers(p)
+	if p.markDisconnectNotified() {
+		s.notifyDisconnection(p, audience, rooms, reason)
+	}
+	s.publishPresence(p)
+	s.interceptDisconnect(p, reason)
+}
+
+// notifyDisconnection sends a DisconnectionNotification about p to audience, and to the
+// peers of the other nodes that shared one of rooms with it, or that are outside rooms
+// like it if rooms is empty.
+func (s *SignalingServer) notifyDisconnection(p *peer, audience []*peer, rooms []string, reason message.DisconnectReason) {
+	content, err := json.Marshal(message.DisconnectionNotificationContent{DisconnectedPeerID: p.id, Reason: reason})
+	if err != nil {
+		p.logger.Error("Failed to notify peers of disconnection", "error", err)
+		return
+	}
+	notification := message.Message{
+		Kind:    message.DisconnectionNotification,
+		Reach:   message.AllPeers,
+		Sender:  "server",
+		PeerID:  p.id,
+		Content: content,
+	}
+	for _, other := range audience {
+		if other == p {
+			continue
+		}
+		if err := s.send(other, notification); err != nil {
+			other.logger.Warn("Failed to send disconnection notification", "error", err)
+		}
+	}
+	s.broadcastCluster("", rooms, notification)
+}
+
+// kick disconnects p from the server side with the given close code and text.
+// Other peers are told it was kicked.
+func (s *SignalingServer) kick(p *peer, code int, text string) {
+	p.kicked.Store(true)
+	if !p.closeConn(code, text) {
+		// a detached peer has no connection to close
+		s.expireSession(p, message.DisconnectKicked)
+	}
+}
+
+// disconnectReason classifies why the read loop of p ended with err.
+func disconnectReason(p *peer, err error) message.DisconnectReason {
+	if p.kicked.Load() {
+		return message.DisconnectKicked
+	}
+	if p.quit.Load() {
+		return message.DisconnectGraceful
+	}
+	if closeErr, ok := err.(*websocket.CloseError); ok {
+		switch closeErr.Code {
+		case websocket.CloseNormalClosure, websocket.CloseGoingAway:
+			return message.DisconnectGraceful
+		}
+		return message.DisconnectError
+	}
+	if errors.Is(err, errIdleTimeout) || errors.Is(err, errPongTimeout) {
+		return message.DisconnectTimeout
+	}
+	return message.DisconnectError
+}
+
+// send queues msg on p's write pump, adapted to the protocol of its client. A peer whose
+// queue is full is too slow to keep up and gets disconnected rather than blocking the sender.
+func (s *SignalingServer) send(p *peer, msg message.Message) error {
+	msg, ok := p.adapt(msg)
+	if !ok {
+		return nil
+	}
+	err := p.enqueue(msg)
+	s.kickIfFull(p, err)
+	return err
+}
+
+// sendError answers the request requestID of p with an Error message.
+func (s *SignalingServer) sendError(p *peer, requestID string, code message.ErrorCode, text string) {
+	s.sendErrorContent(p, message.ErrorContent{Code: code, Message: text, RequestID: requestID})
+}
+
+func (s *SignalingServer) sendErrorContent(p *peer, errorContent message.ErrorContent) {
+	content, err := json.Marshal(errorContent)
+	if err != nil {
+		p.logger.Error("Failed to marshal error message", "error", err)
+		return
+	}
+	errorMsg := message.Message{Kind: message.Error, Reach: message.Self, Sender: "server", PeerID: p.id, Content: content}
+	if err = s.send(p, errorMsg); err != nil {
+		p.logger.Warn("Failed to send message to self", "error", err)
+	}
+}
+
+// invalidMessage tells why data could not be unmarshaled into a message.Message, and
+// returns the ID the client gave it, if that much could be read.
+func invalidMessage(data []byte) (message.ErrorCode, string) {
+	var fields struct {
+		ID    string          `json:"id"`
+		Kind  json.RawMessage `json:"kind"`
+		Reach json.RawMessage `json:"reach"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return message.ErrorInvalidMessage, ""
+	}
+	var kind message.MessageType
+	if fields.Kind != nil && kind.UnmarshalJSON(fields.Kind) != nil {
+		return message.ErrorUnknownKind, fields.ID
+	}
+	var reach message.ReachType
+	if fields.Reach != nil && reach.UnmarshalJSON(fields.Reach) != nil {
+		return message.ErrorUnknownReach, fields.ID
+	}
+	return message.ErrorInvalidMessage, fields.ID
+}
+
+// ack tells p what became of its OnePeer message msg.
+func (s *SignalingServer) ack(p *peer, msg message.Message, status message.AckStatus, text string) {
+	content, err := json.Marshal(message.AckContent{MessageID: msg.ID, Status: status, PeerID: msg.PeerID, Message: text})
+	if err != nil {
+		p.logger.Error("Failed to marshal ack", "error", err)
+		return
+	}
+	ackMsg := message.Message{Kind: message.Ack, Reach: message.Self, Sender: "server", PeerID: p.id, Content: content}
+	if err = s.send(p, ackMsg); err != nil {
+		p.logger.Warn("Failed to send message to self", "error", err)
+	}
+}
+
+// failDelivery reports that the OnePeer message msg could not be delivered: with an Ack
+// when the client gave the message an ID to track, with an Error otherwise.
+func (s *SignalingServer) failDelivery(p *peer, msg message.Message, status message.AckStatus, code message.ErrorCode, text string) {
+	if msg.ID != "" {
+		s.ack(p, msg, status, text)
+	} else {
+		s.sendError(p, msg.ID, code, text)
+	}
+}
+
+func (s *SignalingServer) HandleWebSocketConn(w http.ResponseWriter, r *http.Request) {
+	s.serveWebSocket(&s.webSocketUpgrader, w, r)
+}
+
+// HandlerWithOriginPolicy returns a websocket handler that checks origins with policy
+// instead of the server's default one, so each mounted path can have its own policy.
+func (s *SignalingServer) HandlerWithOriginPolicy(policy OriginPolicy) http.HandlerFunc {
+	upgrader := s.webSocketUpgrader
+	upgrader.CheckOrigin = s.checkOrigin(policy)
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveWebSocket(&upgrader, w, r)
+	}
+}
+
+func (s *SignalingServer) serveWebSocket(upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown.Load() {
+		http.Error(w, ErrServerShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	identity, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conn, err := s.upgradeToWebSocketConn(upgrader, w, r, nil)
+	if err != nil {
+		s.logger.Warn("Failed to upgrade to websocket connection", "remote_addr", r.RemoteAddr, "error", err)
+		return
+	}
+	c := newPeerConn(conn, s.codecFor(conn.Subprotocol()))
+	p := s.resumePeer(r, identity, c)
+	if p != nil {
+		p.logger.Info("Resumed socket connection", "remote_addr", r.RemoteAddr)
+		p.connectedFrom(r)
+	} else {
+		p, err = s.registerPeer(c, identity, s.preferredID(r, identity))
+		if errors.Is(err, ErrServerShutdown) {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(s.keepalive.WriteWait))
+			conn.Close()
 			return
 		}
-		var msg message.Message = message.Message{}
-		var responseMsg message.Message = message.Message{
-			Kind:    message.TextMessage,
-			Reach:   message.Self,
-			Sender:  connID,
-			PeerID:  connID,
-			Content: json.RawMessage{},
+		if err != nil {
+			s.logger.Error("Failed to register peer", "remote_addr", r.RemoteAddr, "error", err)
+			conn.Close()
+			return
+		}
+		p.logger.Info("New socket connection", "remote_addr", r.RemoteAddr)
+		p.connectedFrom(r)
+		if !s.interceptConnect(p, c, r) {
+			return
 		}
-		if !s.identifyMessageSender {
-			responseMsg.Sender = ""
+		s.deliverQueued(p)
+		s.announcePeer(p)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	err = p.readLoop(c, func(data []byte) {
+		s.handleMessage(ctx, p, c.codec, data)
+	})
+	if p.kicked.Load() {
+		p.logger.Info("Peer was disconnected by the server")
+	} else if p.quit.Load() {
+		p.logger.Info("Peer disconnected")
+	} else if closeErr, ok := err.(*websocket.CloseError); ok {
+		switch closeErr.Code {
+		case websocket.CloseNormalClosure, websocket.CloseGoingAway:
+			p.logger.Info("Client disconnected gracefully")
+		default:
+			p.logger.Warn("Websocket closed with unexpected code", "code", closeErr.Code, "error", err)
 		}
-		err = json.Unmarshal(p, &msg)
+	} else if errors.Is(err, errIdleTimeout) {
+		p.logger.Info("Evicting idle peer")
+		c.closeWith(websocket.ClosePolicyViolation, "idle timeout")
+	} else if errors.Is(err, errPongTimeout) {
+		p.logger.Info("Evicting unresponsive peer")
+	} else {
+		p.logger.Warn("Error reading message", "error", err)
+	}
+	s.connectionLost(p, c, err)
+}
+
+// connectionLost cleans up after the connection c of p ended with err. The peer is held
+// for resumption when possible, otherwise it is unregistered.
+func (s *SignalingServer) connectionLost(p *peer, c *peerConn, err error) {
+	c.abort()
+	if p.current() == c {
+		reason := disconnectReason(p, err)
+		if !s.canResume(p, err) || !s.detachPeer(p, c, reason) {
+			s.unregisterPeer(p, reason)
+		}
+	}
+	// the write pump closes the connection once it has sent what is left
+	<-c.pumpDone
+}
+
+// handleMessage processes a single raw message read from p and encoded with codec.
+// ctx is canceled when the connection of p closes.
+func (s *SignalingServer) handleMessage(ctx context.Context, p *peer, codec message.Codec, data []byte) {
+	start := time.Now()
+	connID := p.id
+	// a peer that sent Disconnect is only waiting for its connection to close
+	if p.quit.Load() {
+		return
+	}
+	var msg message.Message = message.Message{}
+	var responseMsg message.Message = message.Message{
+		Kind:    message.TextMessage,
+		Reach:   message.Self,
+		Sender:  connID,
+		PeerID:  connID,
+		Content: json.RawMessage{},
+	}
+	if !s.identifyMessageSender {
+		responseMsg.Sender = ""
+	}
+	err := codec.Decode(data, &msg)
+	p.countReceived(msg.Kind, err == nil)
+	if err != nil {
+		s.metrics.unmarshalFailures.Add(1)
+		p.logger.Info("Failed to decode message", "error", err)
+		code, requestID := message.ErrorInvalidMessage, ""
+		if codec == message.JSON {
+			code, requestID = invalidMessage(data)
+		}
+		switch code {
+		case message.ErrorUnknownKind:
+			s.sendError(p, requestID, code, "Unknown message kind")
+		case message.ErrorUnknownReach:
+			s.sendError(p, requestID, code, "Unknown reach type")
+		default:
+			s.sendError(p, requestID, code, "Invalid message structure")
+		}
+		return
+	}
+	s.metrics.messageReceived(msg)
+	if p.logger.Enabled(ctx, slog.LevelDebug) {
+		p.logger.Debug("Message received", s.messageAttrs(msg)...)
+	}
+	if !s.allowMessage(p, msg) {
+		return
+	}
+	// only interceptors can set who recipients see as the sender
+	msg.Sender = ""
+	if !s.interceptMessage(ctx, p, &msg) {
+		return
+	}
+	p.noteFeatures(msg)
+	responseMsg.ID = msg.ID
+	switch msg.Kind {
+	case message.GetAllPeerIDs:
+		scope, ok := s.discoveryScope(p, msg.Room)
+		if !ok {
+			s.sendError(p, msg.ID, message.ErrorNotInRoom, fmt.Sprintf("Not a member of room %s", msg.Room))
+			return
+		}
+		peerIDs := s.peerIDs(scope, p)
+		rooms := s.rooms.roomsOf(p)
+		if msg.Room != "" {
+			rooms = []string{msg.Room}
+		}
+		remote, err := s.remotePeerIDs(rooms)
 		if err != nil {
-			log.Printf("Error unmarshaling message %v\n", err)
-			responseMsg.Content, err = json.Marshal(message.TextMessageContent{"error", "Invalid message structure"})
-			if err != nil {
-				log.Println("Error marshalling message")
-			}
-			conn.WriteJSON(responseMsg)
-			continue
+			p.logger.Error("Failed to list the peers of the cluster", "error", err)
+			s.sendError(p, msg.ID, message.ErrorInternal, "Failed to fetch peer IDs")
+			return
 		}
-		switch msg.Kind {
-		case message.GetAllPeerIDs:
-			peerIDs := s.GetAllPeerIDs()
-			if !s.addSelfToGetPeerIDs {
-				selfIndex := slices.Index(peerIDs, connID)
-				if selfIndex != -1 {
-					peerIDs = slices.Delete(peerIDs, selfIndex, selfIndex+1)
-				} else {
-					log.Printf("connID %v not found in peerIDs", connID)
-				}
-			}
+		peerIDs = append(peerIDs, remote...)
 
-			responseMsg.Content, err = json.Marshal(message.GetAllPeerIDsContent{peerIDs})
-			if err != nil {
-				log.Printf("Error marshalling peer IDs: %v", err)
-				responseMsg.Content, err = json.Marshal(message.TextMessageContent{"error", "Failed to fetch peer IDs"})
-				if err != nil {
-					log.Println("Error marshaling error message")
-					continue
-				}
-				break
-			}
-			responseMsg.Kind = message.GetAllPeerIDs
-
-		case message.TextMessage, message.Offer, message.Answer, message.ICECandidate:
-			responseMsg.Kind = msg.Kind
-			responseMsg.Content = msg.Content
-			responseMsg.PeerID = msg.PeerID
-		case message.Disconnect:
-			log.Printf("Disconnect message received from %s", connID)
-			var disconnectContent message.DisconnectContent
-			err := json.Unmarshal(msg.Content, &disconnectContent)
-			if err != nil {
-				log.Printf("Error unmarshaling msg content: %v", err)
-				conn.WriteMessage(websocket.TextMessage, []byte("Failed to disconnect from the signaling server"))
-				continue
-			} else {
-				delete(s.peers, connID)
-				if disconnectContent.NotifyAll {
-					responseMsg.Kind = message.DisconnectionNotification
-					disconnectionNotificationContent := message.DisconnectionNotificationContent{connID}
-					responseMsg.Content, err = json.Marshal(disconnectionNotificationContent)
-					if err != nil {
-						log.Printf("Failed to notify peers of %s disconnection", connID)
-						continue
-					} else {
-						msg.Reach = message.AllPeers // so that the notification gets sent to everybody
-					}
-				}
+		responseMsg.Content, err = json.Marshal(message.GetAllPeerIDsContent{PeersIDs: peerIDs})
+		if err != nil {
+			p.logger.Error("Failed to marshal peer IDs", "error", err)
+			s.sendError(p, msg.ID, message.ErrorInternal, "Failed to fetch peer IDs")
+			return
+		}
+		responseMsg.Kind = message.GetAllPeerIDs
+		responseMsg.Room = msg.Room
 
-			}
+	case message.TextMessage, message.Offer, message.Answer, message.ICECandidate:
+		responseMsg.Kind = msg.Kind
+		responseMsg.Content = msg.Content
+		responseMsg.PeerID = msg.PeerID
+	case message.Disconnect:
+		p.logger.Info("Disconnect message received")
+		var disconnectContent message.DisconnectContent
+		err := json.Unmarshal(msg.Content, &disconnectContent)
+		if err != nil {
+			p.logger.Info("Invalid message content", s.messageAttrs(msg)...)
+			s.sendError(p, msg.ID, message.ErrorInvalidMessage, "Failed to disconnect from the signaling server")
+			return
+		}
+		// a peer disconnecting without NotifyAll keeps its other peers unaware
+		if !disconnectContent.NotifyAll {
+			p.markDisconnectNotified()
+		}
+		// the peer is unregistered once its connection is closed, like for any other
+		// connection loss, and what it sends until then is dropped
+		p.quit.Store(true)
+		p.closeConn(websocket.CloseNormalClosure, "disconnected")
+		return
 
-		case message.IdentifySelf:
-			responseMsg.Kind = msg.Kind
-			responseMsg.Reach = message.Self
-			msgContent, err := json.Marshal(message.IdentifySelfContent{connID})
-			if err != nil {
-				log.Printf("Error marshalling msg content: %v", err)
+	case message.JoinRoom, message.LeaveRoom:
+		var roomContent message.JoinRoomContent
+		if err := json.Unmarshal(msg.Content, &roomContent); err != nil || !validRoomName(roomContent.Room) {
+			s.sendError(p, msg.ID, message.ErrorInvalidMessage, "Invalid room")
+			return
+		}
+		room := roomContent.Room
+		if msg.Kind == message.JoinRoom {
+			if !s.rooms.join(room, p) {
+				s.sendError(p, msg.ID, message.ErrorAlreadyInRoom, fmt.Sprintf("Already a member of room %s", room))
+				return
 			}
-			responseMsg.Content = msgContent
-		default:
-			log.Printf("unexpected Message type: %v", msg.Kind)
-			responseMsg.Kind = message.TextMessage
-			textMessageContent := message.TextMessageContent{Title: "", Message: "unexpected message type"}
-			responseMsg.Content, err = json.Marshal(textMessageContent)
-			if err != nil {
-				log.Printf("Error marshalling msg content: %v", err)
-			} else {
-				conn.WriteJSON(responseMsg)
+			p.logger.Info("Peer joined room", "room", room)
+			responseMsg.Content, err = json.Marshal(message.JoinRoomContent{Room: room, PeerID: connID})
+		} else {
+			if !s.rooms.leave(room, p) {
+				s.sendError(p, msg.ID, message.ErrorNotInRoom, fmt.Sprintf("Not a member of room %s", room))
+				return
 			}
-			continue
+			p.logger.Info("Peer left room", "room", room)
+			responseMsg.Content, err = json.Marshal(message.LeaveRoomContent{Room: room, PeerID: connID})
 		}
-
-		if msg.Reach == message.Self || msg.PeerID == connID {
-			responseMsg.Sender = "server"
+		if err != nil {
+			p.logger.Error("Failed to marshal message content", "kind", msg.Kind, "error", err)
+			return
+		}
+		s.notifyRoom(p, msg.Kind, room)
+		s.updateClusterPeer(p)
+		s.publishPresence(p)
+		responseMsg.Kind = msg.Kind
+		responseMsg.Room = room
+		msg.Reach = message.Self
+	case message.SubscribePeers:
+		if msg.Room != "" && !s.rooms.isMember(msg.Room, p) {
+			s.sendError(p, msg.ID, message.ErrorNotInRoom, fmt.Sprintf("Not a member of room %s", msg.Room))
+			return
+		}
+		s.subscribePeers(p, msg.Room)
+		return
+	case message.UnsubscribePeers:
+		s.unsubscribePeers(p)
+		return
+	case message.GetPeers:
+		var getPeersContent message.GetPeersContent
+		if len(msg.Content) > 0 {
+			if err := json.Unmarshal(msg.Content, &getPeersContent); err != nil {
+				s.sendError(p, msg.ID, message.ErrorInvalidMessage, "Invalid GetPeers request")
+				return
+			}
+		}
+		for field := range getPeersContent.Filter {
+			if !validFilterField(field) {
+				s.sendError(p, msg.ID, message.ErrorInvalidMessage, fmt.Sprintf("Unknown filter field %s", field))
+				return
+			}
+		}
+		scope, ok := s.discoveryScope(p, msg.Room)
+		if !ok {
+			s.sendError(p, msg.ID, message.ErrorNotInRoom, fmt.Sprintf("Not a member of room %s", msg.Room))
+			return
+		}
+		responseMsg.Content, err = json.Marshal(message.GetPeersContent{Peers: s.peerInfos(scope, p, getPeersContent.Filter)})
+		if err != nil {
+			p.logger.Error("Failed to marshal peers", "error", err)
+			s.sendError(p, msg.ID, message.ErrorInternal, "Failed to fetch peers")
+			return
+		}
+		responseMsg.Kind = message.GetPeers
+		responseMsg.Room = msg.Room
+		msg.Reach = message.Self
+	case message.SetMetadata:
+		var metadataContent message.SetMetadataContent
+		if err := json.Unmarshal(msg.Content, &metadataContent); err != nil || !validMetadata(metadataContent.Metadata) {
+			s.sendError(p, msg.ID, message.ErrorInvalidMessage, "Invalid metadata")
+			return
 		}
+		s.updateMetadata(p, metadataContent.Metadata)
+		responseMsg.Kind = msg.Kind
+		responseMsg.Content, err = json.Marshal(message.SetMetadataContent{PeerID: connID, Metadata: metadataContent.Metadata})
+		if err != nil {
+			p.logger.Error("Failed to marshal message content", "kind", msg.Kind, "error", err)
+			return
+		}
+		msg.Reach = message.Self
+	case message.Hello:
+		s.handshake(p, msg)
+		return
+	case message.IdentifySelf:
+		// metadata is optional, older clients send no content at all
+		var identifyContent message.IdentifySelfContent
+		if json.Unmarshal(msg.Content, &identifyContent) == nil && identifyContent.Metadata != nil {
+			if !validMetadata(*identifyContent.Metadata) {
+				s.sendError(p, msg.ID, message.ErrorInvalidMessage, "Invalid metadata")
+				return
+			}
+			s.updateMetadata(p, *identifyContent.Metadata)
+		}
+		metadata := p.getMetadata()
+		responseMsg.Kind = msg.Kind
+		responseMsg.Reach = message.Self
+		msgContent, err := json.Marshal(message.IdentifySelfContent{ID: connID, ResumeToken: p.token(), Metadata: &metadata})
+		if err != nil {
+			p.logger.Error("Failed to marshal message content", "kind", msg.Kind, "error", err)
+		}
+		responseMsg.Content = msgContent
+	default:
+		if !msg.Kind.IsCustom() {
+			p.logger.Info("Unexpected message kind", "kind", msg.Kind)
+			s.sendError(p, msg.ID, message.ErrorUnknownKind, "unexpected message type")
+			return
+		}
+		var relay bool
+		if msg, relay = s.handleCustomKind(p, msg); !relay {
+			return
+		}
+		responseMsg.Kind = msg.Kind
+		responseMsg.Content = msg.Content
+		responseMsg.PeerID = msg.PeerID
+	}
+
+	if msg.Sender != "" {
+		responseMsg.Sender = msg.Sender
+	}
+	if msg.Reach == message.Self || msg.PeerID == connID {
+		responseMsg.Sender = "server"
+	}
+
+	s.route(p, msg, responseMsg)
+	switch msg.Reach {
+	case message.OnePeer, message.AllPeers, message.Room:
+		s.metrics.relayDone(start)
+	}
+}
 
-		switch msg.Reach {
-		case message.OnePeer:
-			peerConn, exist := s.peers[msg.PeerID]
-			if !exist {
-				logMsg := fmt.Sprintf("Peer ID %s does not exist", msg.PeerID)
-				log.Println(logMsg)
-				responseMsg.Kind = message.TextMessage
-				responseMsg.Content, err = json.Marshal(message.TextMessageContent{"error", logMsg})
-				responseMsg.Reach = message.Self
-				responseMsg.Sender = "server"
+// route delivers responseMsg according to the reach requested by msg.
+func (s *SignalingServer) route(p *peer, msg message.Message, responseMsg message.Message) {
+	connID := p.id
+	switch msg.Reach {
+	case message.OnePeer:
+		if _, local := s.peers.get(msg.PeerID); !local && s.relayRemote(p, msg, responseMsg) {
+			return
+		}
+		s.reportDelivery(p, msg, s.deliverLocal(connID, msg, responseMsg))
+	case message.AllPeers:
+		recipients := 0
+		for _, peerConn := range s.visiblePeers(p) {
+			if peerConn.id != connID {
+				err := s.send(peerConn, responseMsg)
 				if err != nil {
-					log.Println("Error marshaling error message")
+					peerConn.logger.Warn("Failed to relay message", "kind", msg.Kind, "sender", connID, "error", err)
 					continue
 				}
-				conn.WriteJSON(responseMsg)
-				break
+				s.metrics.messageRelayed(msg)
+				recipients++
 			}
-			err = peerConn.WriteJSON(responseMsg)
-
-			if err != nil {
-				log.Printf("Failed to send message to peer %s: %v\n", msg.PeerID, err)
-			}
-		case message.AllPeers:
-			for peerID, peerConn := range s.peers {
-				if peerID != connID {
-					err = peerConn.WriteJSON(responseMsg)
-					if err != nil {
-						log.Printf("Failed to send message to peer %s: %v\n", peerID, err)
-					}
+		}
+		s.metrics.broadcastSent(recipients)
+		s.broadcastCluster(connID, s.rooms.roomsOf(p), responseMsg)
+	case message.Room:
+		if !s.rooms.isMember(msg.Room, p) {
+			s.sendError(p, msg.ID, message.ErrorNotInRoom, fmt.Sprintf("Not a member of room %s", msg.Room))
+			return
+		}
+		responseMsg.Reach = message.Room
+		responseMsg.Room = msg.Room
+		recipients := 0
+		for _, member := range s.rooms.members(msg.Room) {
+			if member != p {
+				err := s.send(member, responseMsg)
+				if err != nil {
+					member.logger.Warn("Failed to relay message", "kind", msg.Kind, "sender", connID, "error", err)
+					continue
 				}
+				s.metrics.messageRelayed(msg)
+				recipients++
 			}
-		case message.Self:
-			err = conn.WriteJSON(responseMsg)
-			if err != nil {
-				log.Printf("Failed to send message to self %s: %v\n", connID, err)
-			}
-		case message.None:
-			continue
-		default:
-			log.Printf("unexpected message reach type: %v", msg.Reach)
-			conn.WriteMessage(websocket.TextMessage, []byte("Unexpected message reach type"))
-			continue
 		}
+		s.metrics.broadcastSent(recipients)
+		s.broadcastCluster(connID, []string{msg.Room}, responseMsg)
+	case message.Self:
+		err := s.send(p, responseMsg)
+		if err != nil {
+			p.logger.Warn("Failed to send message to self", "error", err)
+		}
+	case message.None:
+		return
+	default:
+		p.logger.Info("Unexpected message reach", "reach", msg.Reach)
+		s.sendError(p, msg.ID, message.ErrorUnknownReach, "Unexpected message reach type")
+	}
+}
+
+// deliverLocal delivers responseMsg, the OnePeer message msg of the peer sender, to its
+// recipient on this node, or holds it if the recipient is offline.
+func (s *SignalingServer) deliverLocal(sender string, msg message.Message, responseMsg message.Message) DeliveryReport {
+	target, exist := s.peers.get(msg.PeerID)
+	if !exist {
+		queued, err := s.queue(msg.PeerID, responseMsg)
+		switch {
+		case queued:
+			return DeliveryReport{Status: message.AckQueued, Text: fmt.Sprintf("Peer %s is offline, message queued", msg.PeerID), Held: true}
+		case errors.Is(err, ErrRecipientQueueFull):
+			return DeliveryReport{Status: message.AckFailed, Failed: true, Code: message.ErrorQueueFull, Text: fmt.Sprintf("Queue of offline peer %s is full", msg.PeerID)}
+		case err != nil:
+			s.logger.Error("Failed to queue message for offline peer", "target", msg.PeerID, "sender", sender, "error", err)
+			return DeliveryReport{Status: message.AckFailed, Failed: true, Code: message.ErrorInternal, Text: fmt.Sprintf("Failed to queue message for offline peer %s", msg.PeerID)}
+		}
+		return DeliveryReport{Status: message.AckUnknownPeer, Failed: true, Code: message.ErrorUnknownPeer, Text: fmt.Sprintf("Peer ID %s does not exist", msg.PeerID)}
+	}
+	if err := s.send(target, responseMsg); err != nil {
+		target.logger.Warn("Failed to relay message", "kind", msg.Kind, "sender", sender, "error", err)
+		return DeliveryReport{Status: message.AckFailed, Failed: true, Code: message.ErrorInternal, Text: fmt.Sprintf("Failed to send message to peer %s", msg.PeerID)}
+	}
+	s.metrics.messageRelayed(msg)
+	if target.current() == nil {
+		// a detached peer gets the message if it resumes its session
+		return DeliveryReport{Status: message.AckQueued, Text: fmt.Sprintf("Peer %s is reconnecting, message queued", msg.PeerID)}
+	}
+	return DeliveryReport{Status: message.AckDelivered}
+}
+
+// reportDelivery tells p what became of its OnePeer message msg: errors always, the
+// other outcomes when p asked for an ack.
+func (s *SignalingServer) reportDelivery(p *peer, msg message.Message, report DeliveryReport) {
+	switch {
+	case report.Status == message.AckUnknownPeer:
+		s.metrics.unknownPeers.Add(1)
+		p.logger.Info("Target peer does not exist", "kind", msg.Kind, "target", msg.PeerID)
+		s.failDelivery(p, msg, report.Status, report.Code, report.Text)
+	case report.Failed:
+		s.failDelivery(p, msg, report.Status, report.Code, report.Text)
+	case msg.ID != "" || report.Held:
+		s.ack(p, msg, report.Status, report.Text)
 	}
 }
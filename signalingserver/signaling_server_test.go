@@ -0,0 +1,267 @@
+package signalingserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+	"github.com/gorilla/websocket"
+)
+
+func TestAuthenticator(t *testing.T) {
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (*auth.Identity, error) {
+		switch r.Header.Get("Authorization") {
+		case "":
+			return nil, auth.Unauthorized("missing token")
+		case "Bearer alice":
+			return &auth.Identity{Subject: "alice", Claims: map[string]any{"role": "host"}}, nil
+		default:
+			return nil, auth.Forbidden("invalid token")
+		}
+	})
+	s := NewSignalingServer(10, true, false, WithAuthenticator(authenticator))
+	url := newTestServer(t, s)
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "Bearer mallory": http.StatusForbidden} {
+		_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {token}})
+		if err == nil {
+			t.Fatalf("connected with token %q", token)
+		}
+		if resp == nil || resp.StatusCode != status {
+			t.Errorf("token %q: got response %v, want status %d", token, resp, status)
+		}
+		if token == "" && resp != nil && resp.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("401 response has no WWW-Authenticate challenge")
+		}
+	}
+	if ids := s.GetAllPeerIDs(); len(ids) != 0 {
+		t.Errorf("rejected requests registered peers %v", ids)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, conn)
+	p, ok := s.Peer(c.identify())
+	if !ok {
+		t.Fatal("authenticated peer is not registered")
+	}
+	if identity := p.Identity(); identity == nil || identity.Subject != "alice" || identity.Claims["role"] != "host" {
+		t.Errorf("peer has identity %+v, want the one of the authenticator", identity)
+	}
+}
+
+// receiveDisconnection returns the content of the next DisconnectionNotification.
+func (c *testClient) receiveDisconnection() message.DisconnectionNotificationContent {
+	c.t.Helper()
+	var content message.DisconnectionNotificationContent
+	unmarshalContent(c.t, c.receiveKind(message.DisconnectionNotification), &content)
+	return content
+}
+
+func TestDisconnectionNotifications(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithKeepalive(KeepaliveConfig{IdleTimeout: 300 * time.Millisecond}))
+	url := newTestServer(t, s)
+	// keeps sending so that it is never idle
+	observer := dialLegacy(t, url)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+				observer.WriteJSON(message.Message{Kind: message.TextMessage, Reach: message.None})
+			}
+		}
+	}()
+
+	tests := []struct {
+		name       string
+		disconnect func(c *testClient, id string)
+		reason     message.DisconnectReason
+	}{
+		{"abrupt", func(c *testClient, id string) { c.UnderlyingConn().Close() }, message.DisconnectError},
+		{"close frame", func(c *testClient, id string) {
+			c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		}, message.DisconnectGraceful},
+		{"Disconnect message", func(c *testClient, id string) {
+			c.sendContent(message.Disconnect, message.Self, message.DisconnectContent{NotifyAll: true})
+		}, message.DisconnectGraceful},
+		{"kicked", func(c *testClient, id string) { s.Disconnect(id, "bye") }, message.DisconnectKicked},
+		{"idle", func(c *testClient, id string) {}, message.DisconnectTimeout},
+	}
+	for _, test := range tests {
+		c := dialLegacy(t, url)
+		id := c.identify()
+		test.disconnect(c, id)
+		notification := observer.receiveDisconnection()
+		if notification.DisconnectedPeerID != id || notification.Reason != test.reason {
+			t.Errorf("%s: got %+v, want peer %s with reason %v", test.name, notification, id, test.reason)
+		}
+	}
+	waitFor(t, "only the observer to be left", func() bool { return s.peers.len() == 1 })
+}
+
+func TestDisconnectMessage(t *testing.T) {
+	var disconnects atomic.Int32
+	s := NewSignalingServer(10, true, false, WithInterceptors(InterceptorFuncs{
+		Disconnect: func(p *Peer, reason message.DisconnectReason) { disconnects.Add(1) },
+	}))
+	url := newTestServer(t, s)
+	observer := dialFeatures(t, url, message.FeatureRooms)
+
+	// a peer leaving quietly closes its connection without telling anyone
+	quiet := dialLegacy(t, url)
+	quiet.identify()
+	quiet.sendContent(message.Disconnect, message.Self, message.DisconnectContent{NotifyAll: false})
+	var closeErr *websocket.CloseError
+	if err := quiet.closed(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+		t.Errorf("connection closed with %v, want a normal closure", err)
+	}
+	waitFor(t, "the quiet peer to be unregistered", func() bool { return s.peers.len() == 1 })
+	observer.expectNothing(100 * time.Millisecond)
+
+	// what a peer sends after Disconnect is dropped
+	leaving := dialFeatures(t, url, message.FeatureRooms)
+	id := leaving.identify()
+	data, _ := json.Marshal(message.DisconnectContent{NotifyAll: true})
+	text, _ := json.Marshal(message.TextMessageContent{Message: "still here"})
+	room, _ := json.Marshal(message.JoinRoomContent{Room: "r"})
+	for _, msg := range []message.Message{
+		{Kind: message.Disconnect, Reach: message.Self, Content: data},
+		{Kind: message.TextMessage, Reach: message.AllPeers, Content: text},
+		{Kind: message.JoinRoom, Reach: message.Self, Content: room},
+	} {
+		// the connection may already be closed by the time the last ones are written
+		leaving.WriteJSON(msg)
+	}
+	if notification := observer.receive(); notification.Kind != message.DisconnectionNotification {
+		t.Errorf("observer got %v %s, want only the disconnection notification", notification.Kind, notification.Content)
+	}
+	leaving.closed()
+	waitFor(t, "the leaving peer to be unregistered", func() bool { return s.peers.len() == 1 })
+	if _, exist := s.Peer(id); exist || len(s.GetRooms()) != 0 {
+		t.Errorf("peer that sent Disconnect is still registered, or in rooms %v", s.GetRooms())
+	}
+	observer.expectNothing(100 * time.Millisecond)
+	if n := disconnects.Load(); n != 2 {
+		t.Errorf("interceptors were told of %d disconnections, want 2", n)
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	c := dial(t, url)
+	c.identify()
+
+	tests := []struct {
+		name      string
+		data      string
+		code      message.ErrorCode
+		requestID string
+	}{
+		{"not JSON", `hello`, message.ErrorInvalidMessage, ""},
+		{"unknown kind", `{"id":"r1","kind":"Bogus","reach":"Self"}`, message.ErrorUnknownKind, "r1"},
+		{"unknown reach", `{"id":"r2","kind":"Offer","reach":"Far"}`, message.ErrorUnknownReach, "r2"},
+		{"bad content", `{"id":"r3","kind":"JoinRoom","reach":"Self","content":{"room":42}}`, message.ErrorInvalidMessage, "r3"},
+		{"unknown peer", `{"kind":"Offer","reach":"OnePeer","peerID":"nobody","content":{}}`, message.ErrorUnknownPeer, ""},
+		{"not in room", `{"id":"r4","kind":"Offer","reach":"Room","room":"r","content":{}}`, message.ErrorNotInRoom, "r4"},
+		{"unexpected kind", `{"id":"r5","kind":"Welcome","reach":"Self","content":{}}`, message.ErrorUnknownKind, "r5"},
+	}
+	for _, test := range tests {
+		if err := c.WriteMessage(websocket.TextMessage, []byte(test.data)); err != nil {
+			t.Fatal(err)
+		}
+		msg := c.receive()
+		if msg.Kind != message.Error || msg.Sender != "server" {
+			t.Errorf("%s: got a %v message from %q, want an error from the server", test.name, msg.Kind, msg.Sender)
+			continue
+		}
+		var content message.ErrorContent
+		unmarshalContent(t, msg, &content)
+		if content.Code != test.code || content.RequestID != test.requestID || content.Message == "" {
+			t.Errorf("%s: got %+v, want code %v for request %q", test.name, content, test.code, test.requestID)
+		}
+	}
+}
+
+// Clients that did not negotiate errors get them as text messages titled "error".
+func TestLegacyErrorMessages(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	c := dialLegacy(t, url)
+	c.send(message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: "nobody", Content: json.RawMessage(`{}`)})
+	msg := c.receive()
+	var content message.TextMessageContent
+	unmarshalContent(t, msg, &content)
+	if msg.Kind != message.TextMessage || content.Title != "error" || content.Message == "" {
+		t.Errorf("got %v message %+v, want a text message titled error", msg.Kind, content)
+	}
+}
+
+func TestAcks(t *testing.T) {
+	s := NewSignalingServer(10, true, false)
+	url := newTestServer(t, s)
+	a, b := dial(t, url), dial(t, url)
+	idB := b.identify()
+
+	tracker := message.NewAckTracker()
+	send := func(target string) (message.AckContent, error) {
+		id := message.NewMessageID()
+		pending := tracker.Expect(id)
+		a.send(message.Message{ID: id, Kind: message.Offer, Reach: message.OnePeer, PeerID: target, Content: json.RawMessage(`{}`)})
+		for {
+			select {
+			case <-pending.Done():
+				return pending.Wait(0)
+			default:
+			}
+			tracker.Handle(a.receive())
+		}
+	}
+
+	if ack, err := send(idB); err != nil || ack.Status != message.AckDelivered || ack.PeerID != idB {
+		t.Errorf("offer to b: got %+v, %v, want delivered", ack, err)
+	}
+	if msg := b.receiveKind(message.Offer); msg.ID == "" {
+		t.Error("b got the offer without its message ID")
+	}
+	if ack, err := send("nobody"); err != nil || ack.Status != message.AckUnknownPeer {
+		t.Errorf("offer to nobody: got %+v, %v, want unknown_peer", ack, err)
+	}
+
+	// only OnePeer messages are acknowledged
+	a.send(message.Message{ID: "broadcast", Kind: message.TextMessage, Reach: message.AllPeers, Content: json.RawMessage(`{}`)})
+	b.receiveKind(message.TextMessage)
+	a.expectNothing(100 * time.Millisecond)
+}
+
+func TestSendFailureReported(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithSendQueueSize(2), WithSessionResumption(time.Minute))
+	url := newTestServer(t, s)
+	sender := dial(t, url)
+	target := dialLegacy(t, url)
+	id := target.identify()
+
+	// messages for a detached peer pile up in its send queue
+	target.UnderlyingConn().Close()
+	waitFor(t, "the connection to be detached", func() bool {
+		p, ok := s.peers.get(id)
+		return ok && p.current() == nil
+	})
+	for i := 0; i < 3; i++ {
+		sender.send(message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: id, Content: json.RawMessage(`{}`)})
+	}
+	if content := sender.receiveError(); content.Code != message.ErrorInternal {
+		t.Errorf("offer to a peer with a full queue: got error %v, want %v", content.Code, message.ErrorInternal)
+	}
+}
@@ -0,0 +1,221 @@
+package signalingserver
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+// ErrRecipientQueueFull is returned by a MessageStore when a recipient's queue is at capacity.
+var ErrRecipientQueueFull = errors.New("recipient queue is full")
+
+// MessageStore holds messages for offline recipients until they connect again.
+// Recipients are opaque keys chosen by the server. Implementations must be safe for
+// concurrent use.
+type MessageStore interface {
+	// Push appends msg to the queue of recipient. It is dropped once expiresAt has passed.
+	Push(recipient string, msg message.Message, expiresAt time.Time) error
+
+	// PopAll removes and returns the unexpired messages of recipient, oldest first.
+	PopAll(recipient string) ([]message.Message, error)
+}
+
+type storedMessage struct {
+	msg       message.Message
+	expiresAt time.Time
+}
+
+// MemoryMessageStore is an in-memory MessageStore with a bounded queue per recipient.
+type MemoryMessageStore struct {
+	mu              sync.Mutex
+	queues          map[string][]storedMessage
+	maxPerRecipient int
+	lastSweep       time.Time
+}
+
+func NewMemoryMessageStore(maxPerRecipient int) *MemoryMessageStore {
+	return &MemoryMessageStore{
+		queues:          make(map[string][]storedMessage),
+		maxPerRecipient: maxPerRecipient,
+	}
+}
+
+func (m *MemoryMessageStore) Push(recipient string, msg message.Message, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	queue := unexpired(m.queues[recipient], now)
+	if m.maxPerRecipient > 0 && len(queue) >= m.maxPerRecipient {
+		m.queues[recipient] = queue
+		return ErrRecipientQueueFull
+	}
+	m.queues[recipient] = append(queue, storedMessage{msg, expiresAt})
+	return nil
+}
+
+func (m *MemoryMessageStore) PopAll(recipient string) ([]message.Message, error) {
+	m.mu.Lock()
+	queue := m.queues[recipient]
+	delete(m.queues, recipient)
+	m.mu.Unlock()
+	var msgs []message.Message
+	for _, stored := range unexpired(queue, time.Now()) {
+		msgs = append(msgs, stored.msg)
+	}
+	return msgs, nil
+}
+
+// sweep drops the expired messages of every recipient, at most once a second.
+func (m *MemoryMessageStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Second {
+		return
+	}
+	m.lastSweep = now
+	for recipient, queue := range m.queues {
+		if queue = unexpired(queue, now); len(queue) == 0 {
+			delete(m.queues, recipient)
+		} else {
+			m.queues[recipient] = queue
+		}
+	}
+}
+
+func unexpired(queue []storedMessage, now time.Time) []storedMessage {
+	kept := queue[:0]
+	for _, stored := range queue {
+		if now.Before(stored.expiresAt) {
+			kept = append(kept, stored)
+		}
+	}
+	return kept
+}
+
+type StoreAndForwardConfig struct {
+	// Where queued messages are kept, defaults to a MemoryMessageStore
+	Store MessageStore
+
+	// How long a message is held, and how long a disconnected peer stays a known recipient
+	TTL time.Duration
+
+	// Capacity of the default in-memory store's queue per recipient
+	MaxPerRecipient int
+}
+
+// WithStoreAndForward makes the server hold OnePeer messages for recipients that went
+// offline, instead of answering that the peer does not exist. A recipient is identified
+// by its authenticated subject when it has one, and otherwise by its peer ID if it got
+// the ID it asked for (see WithPreferredIDs). Held messages are delivered when the
+// recipient connects again. Peers with neither never come back as the same recipient,
+// so messages for them are not held.
+func WithStoreAndForward(config StoreAndForwardConfig) Option {
+	return func(s *SignalingServer) {
+		if config.TTL <= 0 {
+			config.TTL = 5 * time.Minute
+		}
+		if config.Store == nil {
+			if config.MaxPerRecipient <= 0 {
+				config.MaxPerRecipient = 64
+			}
+			config.Store = NewMemoryMessageStore(config.MaxPerRecipient)
+		}
+		s.storeAndForward = &storeAndForward{
+			config:  config,
+			offline: make(map[string]offlineRecipient),
+		}
+	}
+}
+
+// storeAndForward remembers recently disconnected peers so messages for them can be held.
+type storeAndForward struct {
+	config StoreAndForwardConfig
+
+	mu        sync.Mutex
+	offline   map[string]offlineRecipient // by peer ID
+	lastSweep time.Time
+}
+
+type offlineRecipient struct {
+	recipient string
+	expiresAt time.Time
+}
+
+// recipientKeys returns the store keys messages for p can be queued under, none when
+// p will not be recognized once it connects again.
+func recipientKeys(p *peer) []string {
+	var keys []string
+	if p.preferredID {
+		keys = append(keys, "peer:"+p.id)
+	}
+	if p.identity != nil && p.identity.Subject != "" {
+		keys = append(keys, "identity:"+p.identity.Subject)
+	}
+	return keys
+}
+
+// wentOffline records that messages for the ID of p should be held, if p can get them.
+func (f *storeAndForward) wentOffline(p *peer) {
+	keys := recipientKeys(p)
+	if len(keys) == 0 {
+		return
+	}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now.Sub(f.lastSweep) >= time.Second {
+		f.lastSweep = now
+		for id, offline := range f.offline {
+			if !now.Before(offline.expiresAt) {
+				delete(f.offline, id)
+			}
+		}
+	}
+	// the identity outlives the random peer ID, so prefer it
+	f.offline[p.id] = offlineRecipient{keys[len(keys)-1], now.Add(f.config.TTL)}
+}
+
+// recipient returns the store key for an offline peer ID, if messages for it should be held.
+func (f *storeAndForward) recipient(peerID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	offline, exist := f.offline[peerID]
+	if !exist || !time.Now().Before(offline.expiresAt) {
+		return "", false
+	}
+	return offline.recipient, true
+}
+
+// queue holds msg for the offline peer ID and reports whether it did.
+func (s *SignalingServer) queue(peerID string, msg message.Message) (bool, error) {
+	if s.storeAndForward == nil {
+		return false, nil
+	}
+	recipient, known := s.storeAndForward.recipient(peerID)
+	if !known {
+		return false, nil
+	}
+	err := s.storeAndForward.config.Store.Push(recipient, msg, time.Now().Add(s.storeAndForward.config.TTL))
+	return err == nil, err
+}
+
+// deliverQueued sends p the messages that were held for it while it was offline.
+func (s *SignalingServer) deliverQueued(p *peer) {
+	if s.storeAndForward == nil {
+		return
+	}
+	for _, recipient := range recipientKeys(p) {
+		msgs, err := s.storeAndForward.config.Store.PopAll(recipient)
+		if err != nil {
+			p.logger.Error("Failed to load queued messages", "error", err)
+			continue
+		}
+		for _, msg := range msgs {
+			msg.PeerID = p.id
+			if err := s.send(p, msg); err != nil {
+				p.logger.Warn("Failed to deliver queued message", "kind", msg.Kind, "error", err)
+			}
+		}
+	}
+}
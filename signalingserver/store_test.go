@@ -0,0 +1,140 @@
+package signalingserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/auth"
+	"github.com/AbdelrahmanWM/signalingserver/signalingserver/message"
+)
+
+func TestMemoryMessageStore(t *testing.T) {
+	store := NewMemoryMessageStore(2)
+	later := time.Now().Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := store.Push("alice", message.Message{ID: fmt.Sprint(i)}, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Push("alice", message.Message{ID: "2"}, later); !errors.Is(err, ErrRecipientQueueFull) {
+		t.Errorf("pushing past capacity: got %v, want ErrRecipientQueueFull", err)
+	}
+	if err := store.Push("bob", message.Message{ID: "expired"}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := store.PopAll("alice")
+	if err != nil || len(msgs) != 2 || msgs[0].ID != "0" || msgs[1].ID != "1" {
+		t.Errorf("popped %+v, %v, want messages 0 and 1", msgs, err)
+	}
+	if msgs, _ := store.PopAll("alice"); len(msgs) != 0 {
+		t.Errorf("popped %+v again", msgs)
+	}
+	if msgs, _ := store.PopAll("bob"); len(msgs) != 0 {
+		t.Errorf("popped expired messages %+v", msgs)
+	}
+}
+
+// sendOffer sends an Offer to the peer id with a message ID, and returns the ack.
+func (c *testClient) sendOffer(id, messageID string) message.AckContent {
+	c.t.Helper()
+	c.send(message.Message{ID: messageID, Kind: message.Offer, Reach: message.OnePeer, PeerID: id, Content: []byte(`{"sdp":"` + messageID + `"}`)})
+	var ack message.AckContent
+	unmarshalContent(c.t, c.receiveKind(message.Ack), &ack)
+	if ack.MessageID != messageID {
+		c.t.Fatalf("got ack of message %s, want %s", ack.MessageID, messageID)
+	}
+	return ack
+}
+
+func TestStoreAndForward(t *testing.T) {
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (*auth.Identity, error) {
+		return &auth.Identity{Subject: r.URL.Query().Get("user")}, nil
+	})
+	s := NewSignalingServer(10, true, false, WithAuthenticator(authenticator), WithStoreAndForward(StoreAndForwardConfig{MaxPerRecipient: 2}))
+	url := newTestServer(t, s)
+	sender := dial(t, url+"?user=bob")
+	alice := dial(t, url+"?user=alice")
+	id := alice.identify()
+	alice.Close()
+	waitFor(t, "alice to be unregistered", func() bool { return s.peers.len() == 1 })
+
+	for i, status := range []message.AckStatus{message.AckQueued, message.AckQueued, message.AckFailed} {
+		if ack := sender.sendOffer(id, fmt.Sprint("offer-", i)); ack.Status != status {
+			t.Errorf("offer %d acknowledged as %v, want %v", i, ack.Status, status)
+		}
+	}
+	if ack := sender.sendOffer("nobody", "offer-x"); ack.Status != message.AckUnknownPeer {
+		t.Errorf("offer to an unknown peer acknowledged as %v", ack.Status)
+	}
+
+	// alice gets another ID, and the messages held for her subject
+	back := dialLegacy(t, url+"?user=alice")
+	for i := 0; i < 2; i++ {
+		msg := back.receiveKind(message.Offer)
+		if want := fmt.Sprint(`{"sdp":"offer-`, i, `"}`); string(msg.Content) != want {
+			t.Errorf("held offer %d is %s, want %s", i, msg.Content, want)
+		}
+		if msg.PeerID == id {
+			t.Errorf("held offer is addressed to the previous ID of alice")
+		}
+	}
+}
+
+func TestStoreAndForwardRecipients(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithStoreAndForward(StoreAndForwardConfig{}),
+		WithPreferredIDs(AllowPreferredIDPatterns(regexp.MustCompile(`^desk-[0-9]+$`))))
+	url := newTestServer(t, s)
+	sender := dial(t, url)
+
+	// a peer with a generated ID never gets it back, so nothing is held for it
+	anonymous := dial(t, url)
+	anonymousID := anonymous.identify()
+	anonymous.Close()
+	waitFor(t, "the anonymous peer to be unregistered", func() bool { return s.peers.len() == 1 })
+	if ack := sender.sendOffer(anonymousID, "offer-1"); ack.Status != message.AckUnknownPeer {
+		t.Errorf("offer to a peer with a generated ID acknowledged as %v, want unknown_peer", ack.Status)
+	}
+
+	// a peer that chose its ID gets it back
+	desk := dial(t, url+"?"+PreferredIDQueryParam+"=desk-1")
+	if id := desk.identify(); id != "desk-1" {
+		t.Fatalf("got ID %s, want the preferred one", id)
+	}
+	desk.Close()
+	waitFor(t, "desk-1 to be unregistered", func() bool { return s.peers.len() == 1 })
+	if ack := sender.sendOffer("desk-1", "offer-2"); ack.Status != message.AckQueued {
+		t.Errorf("offer to a peer with a preferred ID acknowledged as %v, want queued", ack.Status)
+	}
+	back := dialLegacy(t, url+"?"+PreferredIDQueryParam+"=desk-1")
+	if msg := back.receiveKind(message.Offer); msg.PeerID != "desk-1" || string(msg.Content) != `{"sdp":"offer-2"}` {
+		t.Errorf("got %+v, want the held offer", msg)
+	}
+}
+
+func TestStoreAndForwardLegacySender(t *testing.T) {
+	s := NewSignalingServer(10, true, false, WithStoreAndForward(StoreAndForwardConfig{MaxPerRecipient: 1}),
+		WithPreferredIDs(AllowPreferredIDPatterns(regexp.MustCompile(`^desk-[0-9]+$`))))
+	url := newTestServer(t, s)
+	// a client predating Hello is told about held messages with text messages
+	sender := dialLegacy(t, url)
+	desk := dial(t, url+"?"+PreferredIDQueryParam+"=desk-1")
+	desk.identify()
+	desk.Close()
+	waitFor(t, "desk-1 to be unregistered", func() bool { return s.peers.len() == 1 })
+
+	sender.send(message.Message{Kind: message.Offer, Reach: message.OnePeer, PeerID: "desk-1", Content: []byte(`{}`)})
+	sender.send(message.Message{ID: "offer-2", Kind: message.Offer, Reach: message.OnePeer, PeerID: "desk-1", Content: []byte(`{}`)})
+	for _, title := range []string{"queued", "failed"} {
+		msg := sender.receiveKind(message.TextMessage)
+		var content message.TextMessageContent
+		unmarshalContent(t, msg, &content)
+		if content.Title != title || content.Message == "" {
+			t.Errorf("got text message %+v, want one titled %s", content, title)
+		}
+	}
+}
@@ -6,16 +6,29 @@ import (
 	"math/big"
 )
 
+const idChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// GenerateRandomID returns a random alphanumeric ID of length characters.
+//
+// Deprecated: GenerateRandomID exits the process when the system random source fails.
+// Use RandomID, which returns the error.
 func GenerateRandomID(length int) string {
+	id, err := RandomID(length)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
 
-	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// RandomID returns a random alphanumeric ID of length characters.
+func RandomID(length int) (string, error) {
 	result := make([]byte, length)
 	for i := range result {
-		randInt, err := rand.Int(rand.Reader,big.NewInt(int64(len(chars))))
-		if err!=nil{
-			log.Fatal(err)
+		randInt, err := rand.Int(rand.Reader, big.NewInt(int64(len(idChars))))
+		if err != nil {
+			return "", err
 		}
-		result[i]=chars[randInt.Int64()]
+		result[i] = idChars[randInt.Int64()]
 	}
-	return string(result)
-}
\ No newline at end of file
+	return string(result), nil
+}